@@ -0,0 +1,78 @@
+package db
+
+// BatchReplay receives the decoded operations of a Batch one at a time,
+// tagged with the sequence number the whole group was written at. Table
+// implements it directly, which lets WAL recovery rebuild the MemTable's
+// versions by replaying each recovered group through Table.Put/Delete. A
+// live DB.Write does not go through it; it applies the batch with
+// Table.Write under a single lock acquisition.
+type BatchReplay interface {
+	Put(key []byte, val []byte, seq uint64) error
+	Delete(key []byte, seq uint64) error
+}
+
+type batchOp struct {
+	action Action
+	key    []byte
+	val    []byte
+}
+
+// Batch collects a group of Put/Delete operations that are written to the
+// WAL as a single group and applied to the MemTable as a unit: either every
+// operation in the batch becomes visible, or none of them do.
+type Batch struct {
+	ops []batchOp
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) Put(key []byte, val []byte) {
+	b.ops = append(b.ops, batchOp{action: ActionPut, key: key, val: val})
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{action: ActionDelete, key: key})
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Replay dispatches every operation in the batch, in order, to r, tagging
+// each with seq: the sequence number the whole group shares.
+func (b *Batch) Replay(r BatchReplay, seq uint64) error {
+	for _, op := range b.ops {
+		var err error
+		switch op.action {
+		case ActionPut:
+			err = r.Put(op.key, op.val, seq)
+		case ActionDelete:
+			err = r.Delete(op.key, seq)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchFromRecords rebuilds the Batch a group of WAL records came from, so
+// recovery can replay it as one group through BatchReplay.
+func batchFromRecords(records []Record) *Batch {
+	b := NewBatch()
+	for _, r := range records {
+		switch r.Action {
+		case ActionPut:
+			b.Put(r.Key, r.Val)
+		case ActionDelete:
+			b.Delete(r.Key)
+		}
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestBatch_PutDeleteLenReset(t *testing.T) {
+	b := NewBatch()
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Delete([]byte("k2"))
+	if b.Len() != 2 {
+		t.Fatalf("expected len 2, got %d", b.Len())
+	}
+	b.Reset()
+	if b.Len() != 0 {
+		t.Fatalf("expected len 0 after reset, got %d", b.Len())
+	}
+}
+
+func TestDB_WriteBatch(t *testing.T) {
+	d, err := Open(OptionStorage(NewMemStorage()))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+
+	b := NewBatch()
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Put([]byte("k2"), []byte("v2"))
+	b.Delete([]byte("k1"))
+	if err := d.Write(b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if _, err := d.Get([]byte("k1")); err != ErrorKeyNotFound {
+		t.Fatalf("expected k1 to be deleted, got err: %v", err)
+	}
+	val, err := d.Get([]byte("k2"))
+	if err != nil {
+		t.Fatalf("Get k2 failed: %v", err)
+	}
+	if string(val) != "v2" {
+		t.Fatalf("expected v2, got %s", val)
+	}
+}
+
+func TestDB_WriteBatch_RecoversAsUnit(t *testing.T) {
+	storage := NewMemStorage()
+
+	d, err := Open(OptionStorage(storage))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	b := NewBatch()
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Put([]byte("k2"), []byte("v2"))
+	if err := d.Write(b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	d.Close()
+
+	reopened, err := Open(OptionStorage(storage))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	for _, key := range []string{"k1", "k2"} {
+		if _, err := reopened.Get([]byte(key)); err != nil {
+			t.Errorf("expected %s to survive recovery, got err: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+)
+
+// bloomFilter is a standard Bloom filter using double hashing (two 32-bit
+// hashes combined to simulate k independent ones), the same trick
+// goleveldb's filter policy uses to avoid computing k real hash functions.
+type bloomFilter struct {
+	bits []byte
+	k    int
+}
+
+// defaultBloomBitsPerKey matches goleveldb's default filter density.
+const defaultBloomBitsPerKey = 10
+
+func bloomHashes(key []byte) (h1, h2 uint32) {
+	fnvHash := fnv.New32a()
+	fnvHash.Write(key)
+	return fnvHash.Sum32(), crc32.ChecksumIEEE(key)
+}
+
+// bloomK picks the number of hash probes for a given bits-per-key budget,
+// following the standard ln(2)*bitsPerKey estimate, clamped to a sane range.
+func bloomK(bitsPerKey int) int {
+	k := int(float64(bitsPerKey) * 0.69314718056)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+	return k
+}
+
+// buildBloomFilter builds one filter covering every key in an SST file.
+func buildBloomFilter(keys [][]byte, bitsPerKey int) *bloomFilter {
+	if bitsPerKey <= 0 {
+		bitsPerKey = defaultBloomBitsPerKey
+	}
+	n := len(keys)
+	if n == 0 {
+		n = 1
+	}
+	nbits := n * bitsPerKey
+	if nbits < 64 {
+		nbits = 64
+	}
+	nbytes := (nbits + 7) / 8
+	nbits = nbytes * 8
+
+	f := &bloomFilter{bits: make([]byte, nbytes), k: bloomK(bitsPerKey)}
+	for _, key := range keys {
+		h1, h2 := bloomHashes(key)
+		h := h1
+		for i := 0; i < f.k; i++ {
+			bitpos := h % uint32(nbits)
+			f.bits[bitpos/8] |= 1 << (bitpos % 8)
+			h += h2
+		}
+	}
+	return f
+}
+
+// mayContain reports whether key could be in the filter. False negatives
+// never happen; false positives are expected and just cost a wasted block
+// read.
+func (f *bloomFilter) mayContain(key []byte) bool {
+	if f == nil || len(f.bits) == 0 {
+		return true
+	}
+	nbits := uint32(len(f.bits) * 8)
+	h1, h2 := bloomHashes(key)
+	h := h1
+	for i := 0; i < f.k; i++ {
+		bitpos := h % nbits
+		if f.bits[bitpos/8]&(1<<(bitpos%8)) == 0 {
+			return false
+		}
+		h += h2
+	}
+	return true
+}
+
+func (f *bloomFilter) encode() []byte {
+	buf := make([]byte, 5+len(f.bits))
+	buf[0] = byte(f.k)
+	binary.LittleEndian.PutUint32(buf[1:5], uint32(len(f.bits)))
+	copy(buf[5:], f.bits)
+	return buf
+}
+
+var errShortFilter = errors.New("sstable: truncated bloom filter")
+
+func decodeBloomFilter(buf []byte) (*bloomFilter, error) {
+	if len(buf) < 5 {
+		return nil, errShortFilter
+	}
+	k := int(buf[0])
+	n := binary.LittleEndian.Uint32(buf[1:5])
+	if uint32(len(buf)-5) < n {
+		return nil, errShortFilter
+	}
+	return &bloomFilter{bits: append([]byte(nil), buf[5:5+n]...), k: k}, nil
+}
@@ -0,0 +1,217 @@
+package db
+
+import "sort"
+
+const (
+	// l0CompactionTrigger is how many L0 files accumulate (they may all
+	// overlap each other) before they're merged down into L1.
+	l0CompactionTrigger = 4
+
+	// baseLevelSizeBytes is L1's size target; each level below it grows
+	// ten-fold, the same exponential target goleveldb uses.
+	baseLevelSizeBytes = 10 * 1024 * 1024
+)
+
+func levelTarget(level int) int64 {
+	target := int64(baseLevelSizeBytes)
+	for i := 1; i < level; i++ {
+		target *= 10
+	}
+	return target
+}
+
+func levelSize(files []FileMeta) int64 {
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	return total
+}
+
+// maybeCompact runs at most one compaction step: the first level (L0 by
+// file count, L1+ by total size) found over its target is merged one step
+// down into the level below it. Flushing calls this after every new L0
+// file, so levels drain gradually instead of needing a full cascade.
+func (t *Table) maybeCompact() error {
+	if len(t.manifest.Levels[0]) > l0CompactionTrigger {
+		return t.compactLevel(0)
+	}
+	for level := 1; level < len(t.manifest.Levels); level++ {
+		if levelSize(t.manifest.Levels[level]) > levelTarget(level) {
+			return t.compactLevel(level)
+		}
+	}
+	return nil
+}
+
+// pickVictim always compacts the file whose key range starts lowest first,
+// which keeps compaction sweeping across the keyspace in order.
+func pickVictim(files []FileMeta, cmp Comparator) FileMeta {
+	victim := files[0]
+	for _, f := range files[1:] {
+		if cmp.Compare(f.Smallest, victim.Smallest) < 0 {
+			victim = f
+		}
+	}
+	return victim
+}
+
+func rangeOf(files []FileMeta, cmp Comparator) (lo, hi []byte) {
+	lo, hi = files[0].Smallest, files[0].Largest
+	for _, f := range files[1:] {
+		if cmp.Compare(f.Smallest, lo) < 0 {
+			lo = f.Smallest
+		}
+		if cmp.Compare(f.Largest, hi) > 0 {
+			hi = f.Largest
+		}
+	}
+	return lo, hi
+}
+
+func overlapsRange(f FileMeta, lo, hi []byte, cmp Comparator) bool {
+	return cmp.Compare(f.Largest, lo) >= 0 && cmp.Compare(f.Smallest, hi) <= 0
+}
+
+// prunedVersions drops every version a live snapshot can no longer reach:
+// it keeps everything newer than floor, plus the single newest version at
+// or below floor (the one a snapshot taken right at floor would see).
+// Versions are expected sorted oldest-first, as compactLevel's per-key
+// stable sort by Seq leaves them; ties
+// on Seq break in that append order, so the last one at floor wins,
+// matching latestVersion and resolveVersionedKVs.
+func prunedVersions(versions []entry, floor uint64) []entry {
+	var kept []entry
+	var atFloor *entry
+	for i := range versions {
+		e := versions[i]
+		if e.Seq > floor {
+			kept = append(kept, e)
+			continue
+		}
+		if atFloor == nil || e.Seq >= atFloor.Seq {
+			atFloor = &versions[i]
+		}
+	}
+	if atFloor == nil {
+		return kept
+	}
+	return append([]entry{*atFloor}, kept...)
+}
+
+// compactLevel merges level (L0: every file; L1+: one victim file) with
+// whatever overlaps it in level+1, pruning each key's versions down to
+// only those a live snapshot could still need and dropping a tombstone
+// once it's reached the bottom level, where there's nothing left for it
+// to shadow.
+func (t *Table) compactLevel(level int) error {
+	destLevel := level + 1
+	for len(t.manifest.Levels) <= destLevel {
+		t.manifest.Levels = append(t.manifest.Levels, []FileMeta{})
+	}
+
+	var sources []FileMeta
+	if level == 0 {
+		sources = append(sources, t.manifest.Levels[0]...)
+		sort.Slice(sources, func(i, j int) bool { return sources[i].Num < sources[j].Num })
+	} else {
+		sources = []FileMeta{pickVictim(t.manifest.Levels[level], t.cmp)}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	lo, hi := rangeOf(sources, t.cmp)
+	var overlaps, kept []FileMeta
+	for _, f := range t.manifest.Levels[destLevel] {
+		if overlapsRange(f, lo, hi, t.cmp) {
+			overlaps = append(overlaps, f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+
+	// Destination-level data is older than anything in the source level,
+	// so lay it down first and let the source append after it; every
+	// version survives until pruning below, regardless of which side it
+	// came from.
+	merged := make(map[string][]entry)
+	for _, f := range overlaps {
+		kvs, err := t.readSSTable(f.Num)
+		if err != nil {
+			return err
+		}
+		for _, kv := range kvs {
+			k := string(kv.Key)
+			merged[k] = append(merged[k], entry{Val: kv.Val, Deleted: kv.Deleted, Seq: kv.Seq})
+		}
+	}
+	for _, f := range sources {
+		kvs, err := t.readSSTable(f.Num)
+		if err != nil {
+			return err
+		}
+		for _, kv := range kvs {
+			k := string(kv.Key)
+			merged[k] = append(merged[k], entry{Val: kv.Val, Deleted: kv.Deleted, Seq: kv.Seq})
+		}
+	}
+
+	isBottom := destLevel == len(t.manifest.Levels)-1
+	floor := t.minSnapshotSeq()
+	data := make([]KV, 0, len(merged))
+	for k, versions := range merged {
+		// Stable: two rows for the same key can share a seq (a batch's
+		// Put+Delete of one key), and prunedVersions/the tie-break in
+		// latestVersion/resolveVersionedKVs rely on append order to
+		// settle which one wins.
+		sort.SliceStable(versions, func(i, j int) bool { return versions[i].Seq < versions[j].Seq })
+		versions = prunedVersions(versions, floor)
+		for i, e := range versions {
+			// prunedVersions puts the retained floor version (if any)
+			// first; that's the one a snapshot at or before floor would
+			// see, and it's only safe to drop as a tombstone once nothing
+			// below it could be shadowed.
+			if e.Deleted && isBottom && i == 0 {
+				continue
+			}
+			data = append(data, KV{Key: []byte(k), Val: e.Val, Deleted: e.Deleted, Seq: e.Seq})
+		}
+	}
+	// Stable for the same reason as the per-key sort above: it must not
+	// reorder two rows that share a (key, Seq).
+	sort.Stable(KVs{rows: data, cmp: t.cmp})
+
+	newDest := kept
+	if len(data) > 0 {
+		newFile, err := t.writeLevelSSTable(data)
+		if err != nil {
+			return err
+		}
+		newDest = append(newDest, newFile)
+	}
+	sort.Slice(newDest, func(i, j int) bool { return t.cmp.Compare(newDest[i].Smallest, newDest[j].Smallest) < 0 })
+	t.manifest.Levels[destLevel] = newDest
+
+	if level == 0 {
+		t.manifest.Levels[0] = nil
+	} else {
+		victim := sources[0]
+		remaining := make([]FileMeta, 0, len(t.manifest.Levels[level]))
+		for _, f := range t.manifest.Levels[level] {
+			if f.Num != victim.Num {
+				remaining = append(remaining, f)
+			}
+		}
+		t.manifest.Levels[level] = remaining
+	}
+
+	for _, f := range sources {
+		t.removeSSTable(f.Num)
+	}
+	for _, f := range overlaps {
+		t.removeSSTable(f.Num)
+	}
+
+	return t.manifest.save(t.storage)
+}
@@ -0,0 +1,128 @@
+package db
+
+import "testing"
+
+// newTestTable builds a Table over an in-memory Storage, so compaction and
+// SST tests stay hermetic instead of touching the filesystem.
+func newTestTable(t *testing.T) *Table {
+	cmp := BytewiseComparator{}
+	return &Table{
+		MemTable:        newSkipList(cmp),
+		cmp:             cmp,
+		storage:         NewMemStorage(),
+		manifest:        newManifest(),
+		minSnapshotSeq:  func() uint64 { return maxSeq },
+		bloomBitsPerKey: defaultBloomBitsPerKey,
+	}
+}
+
+// putMem stores a single version directly in table's MemTable, bypassing
+// WAL and sequencing, so compaction tests can set up a table's state with
+// exact control over what each key's versions look like.
+func putMem(table *Table, key string, e entry) {
+	node := table.MemTable.getOrInsert([]byte(key))
+	node.versions = append(node.versions, e)
+}
+
+// flush simulates what DB.compact does when the WAL fills up: swap
+// MemTable into Immutable and write it out as a new L0 file.
+func flush(t *testing.T, table *Table) {
+	table.Immutable = table.MemTable
+	table.MemTable = newSkipList(table.cmp)
+	if err := table.writeSSTable(); err != nil {
+		t.Fatalf("writeSSTable failed: %v", err)
+	}
+}
+
+func TestTable_GetFallsThroughToL0(t *testing.T) {
+	table := newTestTable(t)
+	putMem(table, "k1", entry{Val: []byte("v1"), Seq: 1})
+	flush(t, table)
+	// flush leaves the flushed table in Immutable; clear it so Get has to
+	// find k1 in the L0 file.
+	table.Immutable = nil
+
+	val, err := table.Get([]byte("k1"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if string(val) != "v1" {
+		t.Fatalf("expected v1, got %s", val)
+	}
+	if len(table.manifest.Levels[0]) != 1 {
+		t.Fatalf("expected 1 L0 file, got %d", len(table.manifest.Levels[0]))
+	}
+}
+
+func TestTable_CompactionDropsBottomLevelTombstone(t *testing.T) {
+	table := newTestTable(t)
+
+	putMem(table, "k1", entry{Val: []byte("v1"), Seq: 1})
+	flush(t, table)
+
+	putMem(table, "k1", entry{Deleted: true, Seq: 2})
+	flush(t, table)
+
+	if _, err := table.Get([]byte("k1")); err != ErrorKeyNotFound {
+		t.Fatalf("expected deleted key to stay hidden, got err: %v", err)
+	}
+
+	// Force L0 -> L1, which is still the bottom level here, so the
+	// tombstone should be dropped instead of carried forward forever.
+	if err := table.compactLevel(0); err != nil {
+		t.Fatalf("compactLevel failed: %v", err)
+	}
+	if len(table.manifest.Levels[0]) != 0 {
+		t.Fatalf("expected L0 to be empty after compaction, got %d files", len(table.manifest.Levels[0]))
+	}
+
+	for _, f := range table.manifest.Levels[1] {
+		kvs, err := table.readSSTable(f.Num)
+		if err != nil {
+			t.Fatalf("readSSTable failed: %v", err)
+		}
+		for _, kv := range kvs {
+			if string(kv.Key) == "k1" {
+				t.Fatalf("expected tombstone for k1 to be dropped at the bottom level")
+			}
+		}
+	}
+
+	if _, err := table.Get([]byte("k1")); err != ErrorKeyNotFound {
+		t.Fatalf("expected k1 to remain not found, got err: %v", err)
+	}
+}
+
+// TestTable_FlushRaisesManifestLastSeq guards the SST-side half of seq
+// restoration on reopen: once a version is flushed, the manifest must
+// remember its seq even though the WAL that originally carried it may
+// later be truncated by a WAL compaction.
+func TestTable_FlushRaisesManifestLastSeq(t *testing.T) {
+	table := newTestTable(t)
+
+	putMem(table, "k1", entry{Val: []byte("v1"), Seq: 5})
+	flush(t, table)
+	if table.manifest.LastSeq != 5 {
+		t.Fatalf("expected LastSeq 5, got %d", table.manifest.LastSeq)
+	}
+
+	putMem(table, "k2", entry{Val: []byte("v2"), Seq: 3})
+	flush(t, table)
+	if table.manifest.LastSeq != 5 {
+		t.Fatalf("expected LastSeq to stay at the high-water mark 5, got %d", table.manifest.LastSeq)
+	}
+}
+
+func TestTable_L0CompactionTriggersAutomatically(t *testing.T) {
+	table := newTestTable(t)
+	for i := 0; i <= l0CompactionTrigger; i++ {
+		putMem(table, "k", entry{Val: []byte("v"), Seq: uint64(i + 1)})
+		flush(t, table)
+	}
+	if len(table.manifest.Levels[0]) != 0 {
+		t.Fatalf("expected L0 to have been compacted away, got %d files", len(table.manifest.Levels[0]))
+	}
+	if len(table.manifest.Levels) < 2 || len(table.manifest.Levels[1]) == 0 {
+		t.Fatalf("expected compacted data to land in L1")
+	}
+}
@@ -0,0 +1,78 @@
+package db
+
+import "bytes"
+
+// Comparator defines the key order a Table uses for everything that needs
+// to agree on it: the MemTable, SST block boundaries and binary search,
+// and the iterator's k-way merge. Name identifies the ordering in the
+// manifest, so a database can refuse to open with a different one than it
+// was created with.
+type Comparator interface {
+	Compare(a, b []byte) int
+	Name() string
+}
+
+// SeparatorComparator is an optional extension a Comparator can implement
+// to shrink the keys an SST's block index stores. Separator returns any
+// key s with a <= s < b (the shortest one it can find); Successor returns
+// any key s with s >= a (again, as short as possible). Both just return
+// a unmodified if finding something shorter isn't worth the trouble.
+type SeparatorComparator interface {
+	Comparator
+	Separator(a, b []byte) []byte
+	Successor(a []byte) []byte
+}
+
+// BytewiseComparator orders keys by raw byte value and is the default
+// used when no Comparator option is given.
+type BytewiseComparator struct{}
+
+func (BytewiseComparator) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+func (BytewiseComparator) Name() string            { return "pluto.BytewiseComparator" }
+
+// Separator returns the shortest key in [a, b) it can find by trimming a
+// down to one byte past their shared prefix and incrementing that byte,
+// which is enough to still sort after a and before b.
+func (BytewiseComparator) Separator(a, b []byte) []byte {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	if i >= n || i >= len(a) {
+		return a
+	}
+	if a[i] >= 0xff || a[i]+1 >= b[i] {
+		return a
+	}
+	short := append([]byte(nil), a[:i+1]...)
+	short[i]++
+	return short
+}
+
+// Successor returns the shortest key >= a by keeping a up to its first
+// non-0xff byte and incrementing that byte; a key made only of 0xff bytes
+// is returned unchanged.
+func (BytewiseComparator) Successor(a []byte) []byte {
+	for i, c := range a {
+		if c != 0xff {
+			short := append([]byte(nil), a[:i+1]...)
+			short[i]++
+			return short
+		}
+	}
+	return a
+}
+
+// ReverseComparator orders keys in the opposite order from
+// BytewiseComparator. It doesn't implement SeparatorComparator, which is
+// fine: Separator/Successor are an optional optimization, and an SST
+// index falls back to storing the full key when a Comparator doesn't
+// offer them.
+type ReverseComparator struct{}
+
+func (ReverseComparator) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+func (ReverseComparator) Name() string            { return "pluto.ReverseComparator" }
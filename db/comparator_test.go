@@ -0,0 +1,69 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestReverseComparator_OrdersMemTableAndIterator(t *testing.T) {
+	d, err := Open(OptionStorage(NewMemStorage()), OptionComparator(ReverseComparator{}))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := d.Put([]byte(k), []byte(k)); err != nil {
+			t.Fatalf("Put(%s) failed: %v", k, err)
+		}
+	}
+
+	snap := d.GetSnapshot()
+	defer snap.Release()
+	it := snap.NewIterator(nil, nil)
+	defer it.Release()
+
+	var got []string
+	for ok := it.First(); ok; ok = it.Next() {
+		got = append(got, string(it.Key()))
+	}
+	want := []string{"c", "b", "a"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestOpen_RefusesMismatchedComparator(t *testing.T) {
+	storage := NewMemStorage()
+
+	d, err := Open(OptionStorage(storage), OptionComparator(ReverseComparator{}))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := d.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	d.Close()
+
+	if _, err := Open(OptionStorage(storage)); err == nil {
+		t.Fatalf("expected Open with a mismatched comparator to fail")
+	}
+}
+
+func TestBytewiseComparator_SeparatorAndSuccessor(t *testing.T) {
+	cmp := BytewiseComparator{}
+
+	sep := cmp.Separator([]byte("abc"), []byte("abz"))
+	if cmp.Compare(sep, []byte("abc")) < 0 || cmp.Compare(sep, []byte("abz")) >= 0 {
+		t.Fatalf("expected separator in [abc, abz), got %q", sep)
+	}
+
+	succ := cmp.Successor([]byte("abc"))
+	if cmp.Compare(succ, []byte("abc")) < 0 {
+		t.Fatalf("expected successor >= abc, got %q", succ)
+	}
+}
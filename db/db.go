@@ -1,13 +1,12 @@
 package db
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 type Action int
@@ -47,108 +46,294 @@ func transAction(a byte) Action {
 }
 
 var (
-	ErrorKeyNotFound = errors.New("key not found")
+	ErrorKeyNotFound        = errors.New("key not found")
+	ErrorComparatorMismatch = errors.New("db: comparator does not match the one this database was created with")
 )
 
 type DB struct {
 	WAL   *WAL
 	Table *Table
+
+	// lock is released on Close so a second Open against the same
+	// storage doesn't trip Storage.Lock's already-locked error forever.
+	lock Releaser
+
+	seq uint64
+
+	snapMu        sync.Mutex
+	openSnapshots []uint64
+}
+
+// nextSeq hands out the sequence number shared by every record in a write.
+func (db *DB) nextSeq() uint64 {
+	return atomic.AddUint64(&db.seq, 1)
+}
+
+// maxSeq is a sequence number no real write can ever reach, so reading at
+// maxSeq always means "give me the latest value" rather than a snapshot.
+const maxSeq = ^uint64(0)
+
+// addSnapshot and removeSnapshot track every currently-open snapshot's
+// sequence number, so compaction knows the oldest one (minSnapshotSeq)
+// and never discards a version a live snapshot might still need.
+func (db *DB) addSnapshot(seq uint64) {
+	db.snapMu.Lock()
+	defer db.snapMu.Unlock()
+	db.openSnapshots = append(db.openSnapshots, seq)
+}
+
+func (db *DB) removeSnapshot(seq uint64) {
+	db.snapMu.Lock()
+	defer db.snapMu.Unlock()
+	for i, s := range db.openSnapshots {
+		if s == seq {
+			db.openSnapshots = append(db.openSnapshots[:i], db.openSnapshots[i+1:]...)
+			return
+		}
+	}
+}
+
+func (db *DB) minSnapshotSeq() uint64 {
+	db.snapMu.Lock()
+	defer db.snapMu.Unlock()
+	min := maxSeq
+	for _, s := range db.openSnapshots {
+		if s < min {
+			min = s
+		}
+	}
+	return min
 }
 
 type Table struct {
 	Dir       string
-	MemTable  map[string][]byte
-	Immutable map[string][]byte
-
-	level int
-	mu    sync.Mutex
+	MemTable  *skipList
+	Immutable *skipList
+
+	// cmp orders every key the Table touches: the MemTable, SST block
+	// boundaries and binary search, and the iterator's k-way merge. See
+	// OptionComparator.
+	cmp Comparator
+
+	// storage is where the WAL and every SST/manifest file actually live.
+	// Defaults to a fileStorage over Dir; see OptionStorage.
+	storage Storage
+
+	manifest *Manifest
+	// minSnapshotSeq reports the oldest sequence number a live snapshot
+	// might still read at. Compaction keeps every version newer than it,
+	// plus the single newest version at or below it, and drops the rest.
+	minSnapshotSeq func() uint64
+
+	// bloomBitsPerKey sizes the bloom filter written into every new SST
+	// file; see OptionBloomBitsPerKey.
+	bloomBitsPerKey int
+
+	// cacheMu guards the per-file index/filter/block caches Get reads
+	// through instead of re-parsing an SST file on every lookup.
+	cacheMu     sync.Mutex
+	indexCache  map[int][]indexEntry
+	filterCache map[int]*bloomFilter
+	blockCache  map[blockCacheKey][]byte
+
+	mu sync.Mutex
 }
 
-type KV struct {
-	Key []byte `json:"key"`
-	Val []byte `json:"val"`
+// entry is one version of a key: either a value, or a tombstone recording
+// that the key was deleted, tagged with the sequence number it was
+// written at. MemTable/Immutable keep every version still reachable by a
+// live snapshot instead of overwriting in place.
+type entry struct {
+	Val     []byte
+	Deleted bool
+	Seq     uint64
 }
 
-type KVs []KV
+func entryResult(e entry) ([]byte, error) {
+	if e.Deleted {
+		return nil, ErrorKeyNotFound
+	}
+	return e.Val, nil
+}
 
-func (kvs KVs) Len() int {
-	return len(kvs)
+// latestVersion returns the newest version of a key visible at seq, i.e.
+// the version with the highest Seq that doesn't exceed it. Versions are
+// expected oldest-appended-first; ties on Seq (every op in one Batch
+// shares a seq) break in append order, so the last op in a batch wins,
+// matching resolveVersionedKVs and prunedVersions.
+func latestVersion(versions []entry, seq uint64) (entry, bool) {
+	var best entry
+	found := false
+	for _, e := range versions {
+		if e.Seq <= seq && (!found || e.Seq >= best.Seq) {
+			best = e
+			found = true
+		}
+	}
+	return best, found
 }
-func (kvs KVs) Less(i, j int) bool {
-	return kvs[i].less(kvs[j])
+
+type KV struct {
+	Key     []byte `json:"key"`
+	Val     []byte `json:"val"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Seq     uint64 `json:"seq"`
 }
-func (kvs KVs) Swap(i, j int) {
-	kvs[i], kvs[j] = kvs[j], kvs[i]
+
+// KVs implements sort.Interface under its cmp Comparator; it's only used
+// for the handful of places (compaction's merged-map rebuild) that can't
+// simply inherit an already-ordered source.
+type KVs struct {
+	rows []KV
+	cmp  Comparator
 }
 
-func (kv *KV) less(other KV) bool {
-	return string(kv.Key) < string(other.Key)
+func (kvs KVs) Len() int { return len(kvs.rows) }
+func (kvs KVs) Less(i, j int) bool {
+	if c := kvs.cmp.Compare(kvs.rows[i].Key, kvs.rows[j].Key); c != 0 {
+		return c < 0
+	}
+	return kvs.rows[i].Seq < kvs.rows[j].Seq
 }
+func (kvs KVs) Swap(i, j int) { kvs.rows[i], kvs.rows[j] = kvs.rows[j], kvs.rows[i] }
 
-func (t *Table) Put(key []byte, val []byte) error {
+func (t *Table) Put(key []byte, val []byte, seq uint64) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.MemTable[string(key)] = val
+	node := t.MemTable.getOrInsert(key)
+	node.versions = append(node.versions, entry{Val: val, Seq: seq})
 	return nil
 }
 
+// Get returns the latest value for key, as of the most recent write.
 func (t *Table) Get(key []byte) ([]byte, error) {
+	return t.GetAt(key, maxSeq)
+}
+
+// GetAt consults, in order, the MemTable, the Immutable table being
+// flushed, L0 (newest file first, since L0 files may overlap), and then
+// L1..Lk, binary-searching each level's non-overlapping file ranges for
+// the one file that could hold key. At each step it returns the newest
+// version with a sequence number no greater than seq, which is how a
+// snapshot's reads stay pinned to the moment it was taken.
+func (t *Table) GetAt(key []byte, seq uint64) ([]byte, error) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-	val, ok := t.MemTable[string(key)]
-	if !ok {
-		val, ok = t.Immutable[string(key)]
+	if node, ok := t.MemTable.get(key); ok {
+		if e, ok := latestVersion(node.versions, seq); ok {
+			t.mu.Unlock()
+			return entryResult(e)
+		}
+	}
+	if t.Immutable != nil {
+		if node, ok := t.Immutable.get(key); ok {
+			if e, ok := latestVersion(node.versions, seq); ok {
+				t.mu.Unlock()
+				return entryResult(e)
+			}
+		}
+	}
+	manifest := t.manifest
+	cmp := t.cmp
+	t.mu.Unlock()
+
+	if manifest == nil {
+		return nil, ErrorKeyNotFound
+	}
+
+	l0 := append([]FileMeta(nil), manifest.Levels[0]...)
+	sort.Slice(l0, func(i, j int) bool { return l0[i].Num > l0[j].Num })
+	for _, f := range l0 {
+		if kv, ok, err := t.lookupSSTable(f, key, seq); err != nil {
+			return nil, err
+		} else if ok {
+			return entryResult(entry{Val: kv.Val, Deleted: kv.Deleted, Seq: kv.Seq})
+		}
+	}
+
+	for level := 1; level < len(manifest.Levels); level++ {
+		f, ok := findFileForKey(manifest.Levels[level], key, cmp)
 		if !ok {
-			return nil, ErrorKeyNotFound
+			continue
+		}
+		if kv, ok, err := t.lookupSSTable(f, key, seq); err != nil {
+			return nil, err
+		} else if ok {
+			return entryResult(entry{Val: kv.Val, Deleted: kv.Deleted, Seq: kv.Seq})
 		}
-		return val, nil
 	}
-	return val, nil
+	return nil, ErrorKeyNotFound
 }
 
-func (t *Table) Delete(key []byte) error {
+func (t *Table) Delete(key []byte, seq uint64) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	delete(t.MemTable, string(key))
+	node := t.MemTable.getOrInsert(key)
+	node.versions = append(node.versions, entry{Deleted: true, Seq: seq})
 	return nil
 }
 
-func (t *Table) writeSSTable() error {
+// Write applies every operation in batch, tagged with seq, to the
+// MemTable under a single lock acquisition, so a concurrent Get never
+// observes only part of it.
+func (t *Table) Write(batch *Batch, seq uint64) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	data := make([]KV, 0, len(t.Immutable))
-	for k, v := range t.Immutable {
-		data = append(data, KV{
-			Key: []byte(k),
-			Val: v,
-		})
+	for _, op := range batch.ops {
+		node := t.MemTable.getOrInsert(op.key)
+		switch op.action {
+		case ActionPut:
+			node.versions = append(node.versions, entry{Val: op.val, Seq: seq})
+		case ActionDelete:
+			node.versions = append(node.versions, entry{Deleted: true, Seq: seq})
+		}
+	}
+	return nil
+}
+
+// writeSSTable flushes every version in the Immutable table to a new L0
+// file and then runs one compaction step if any level is now over its
+// target. Nothing is collapsed here; that's compaction's job, once it
+// knows which versions a live snapshot might still need. The skip list
+// already walks in comparator order, so data comes out sorted for free.
+func (t *Table) writeSSTable() error {
+	t.mu.Lock()
+	data := make([]KV, 0, t.Immutable.Len())
+	for n := t.Immutable.front(); n != nil; n = n.forward[0] {
+		for _, e := range n.versions {
+			data = append(data, KV{Key: n.key, Val: e.Val, Deleted: e.Deleted, Seq: e.Seq})
+		}
 	}
-	sort.Sort(KVs(data))
-	f, err := os.Create(fmt.Sprintf("%s/%d.sst", t.Dir, t.level))
+	t.mu.Unlock()
+
+	meta, err := t.writeLevelSSTable(data)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	m := make(map[string]string)
+	t.mu.Lock()
+	t.manifest.Levels[0] = append(t.manifest.Levels[0], meta)
 	for _, kv := range data {
-		m[string(kv.Key)] = string(kv.Val)
+		if kv.Seq > t.manifest.LastSeq {
+			t.manifest.LastSeq = kv.Seq
+		}
 	}
-	mData, _ := json.Marshal(m)
-	_, err = f.Write(mData)
-	if err != nil {
+	t.mu.Unlock()
+
+	if err := t.manifest.save(t.storage); err != nil {
 		return err
 	}
-	t.level++
-	return nil
+	return t.maybeCompact()
 }
 
 func newDefaultDB() *DB {
+	cmp := BytewiseComparator{}
 	return &DB{
 		Table: &Table{
-			Dir:      ".",
-			MemTable: make(map[string][]byte),
-			level:    0,
+			Dir:             ".",
+			MemTable:        newSkipList(cmp),
+			cmp:             cmp,
+			minSnapshotSeq:  func() uint64 { return maxSeq },
+			bloomBitsPerKey: defaultBloomBitsPerKey,
 		},
 	}
 }
@@ -160,15 +345,87 @@ func OptionDir(dir string) Option {
 	}
 }
 
+// OptionBloomBitsPerKey sets how many bits of bloom filter each new SST
+// file spends per key; higher values mean fewer false positives (and
+// fewer wasted block reads) at the cost of a bigger filter.
+func OptionBloomBitsPerKey(n int) Option {
+	return func(db *DB) error {
+		db.Table.bloomBitsPerKey = n
+		return nil
+	}
+}
+
+// OptionComparator sets the key ordering the database uses. It must match
+// the comparator (by Name) the database was originally created with;
+// Open refuses to proceed otherwise, since every SST file on disk was
+// built assuming the original order.
+func OptionComparator(cmp Comparator) Option {
+	return func(db *DB) error {
+		db.Table.cmp = cmp
+		db.Table.MemTable = newSkipList(cmp)
+		return nil
+	}
+}
+
+// OptionStorage sets where the WAL and every SST/manifest file live.
+// Defaults to NewFileStorage(Dir), so Open(nil) writes wal.txt into the
+// process's cwd; pass NewMemStorage() to run hermetically in memory, as
+// the tests do.
+func OptionStorage(s Storage) Option {
+	return func(db *DB) error {
+		db.Table.storage = s
+		return nil
+	}
+}
+
 type Option func(*DB) error
 
 func Open(options ...Option) (*DB, error) {
 	db := newDefaultDB()
 	for _, option := range options {
+		if option == nil {
+			continue
+		}
 		_ = option(db)
 	}
+	if db.Table.storage == nil {
+		db.Table.storage = NewFileStorage(db.Table.Dir)
+	}
+
+	lock, err := db.Table.storage.Lock()
+	if err != nil {
+		return nil, err
+	}
+	db.lock = lock
+
+	manifest, err := loadManifest(db.Table.storage)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Comparator != "" && manifest.Comparator != db.Table.cmp.Name() {
+		return nil, fmt.Errorf("%s: %w", manifest.Comparator, ErrorComparatorMismatch)
+	}
+	if manifest.Comparator == "" {
+		manifest.Comparator = db.Table.cmp.Name()
+		if err := manifest.save(db.Table.storage); err != nil {
+			return nil, err
+		}
+	}
+	db.Table.manifest = manifest
+	db.Table.minSnapshotSeq = db.minSnapshotSeq
+
+	db.WAL = newWAL(db.Table.storage, db.Table)
+
+	// Recovery replays records with their original Seq but never hands
+	// one out itself; restore the counter from the higher of what the
+	// WAL and the manifest saw, so the next write doesn't reuse (and
+	// shadow) a sequence number already on disk.
+	restored := db.WAL.MaxSeq
+	if manifest.LastSeq > restored {
+		restored = manifest.LastSeq
+	}
+	atomic.StoreUint64(&db.seq, restored)
 
-	db.WAL = newWAL(db.Table.Dir)
 	return db, nil
 }
 
@@ -184,6 +441,35 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.search(key)
 }
 
+// Write appends batch to the WAL as a single group and applies it to the
+// MemTable as a unit: either every operation in batch becomes visible, or
+// (on a crash mid-write) none of it does.
+func (db *DB) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	if db.WAL.len() > LogLimit {
+		if err := db.compact(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	seq := db.nextSeq()
+	records := make([]Record, 0, batch.Len())
+	for _, op := range batch.ops {
+		records = append(records, Record{
+			Len:    len(op.key) + len(op.val) + 1 + 1,
+			Key:    op.key,
+			Val:    op.val,
+			Action: op.action,
+			Seq:    seq,
+		})
+	}
+	if err := db.WAL.AppendGroup(seq, records); err != nil {
+		return err
+	}
+	return db.Table.Write(batch, seq)
+}
+
 func (db *DB) append(key []byte, val []byte, action Action) error {
 	if db.WAL.len() > LogLimit {
 		if err := db.compact(); err != nil {
@@ -195,6 +481,7 @@ func (db *DB) append(key []byte, val []byte, action Action) error {
 		Key:    key,
 		Val:    val,
 		Action: action,
+		Seq:    db.nextSeq(),
 	}); err != nil {
 		return err
 	}
@@ -211,19 +498,23 @@ func (db *DB) search(key []byte) ([]byte, error) {
 }
 
 func (db *DB) Close() error {
-	defer db.WAL.close()
-	return nil
+	defer func() {
+		if db.lock != nil {
+			db.lock.Release()
+		}
+	}()
+	return db.WAL.close()
 }
 
 func (db *DB) sync() error {
 	record := db.WAL.last()
 	switch record.Action {
 	case ActionPut:
-		if err := db.Table.Put(record.Key, record.Val); err != nil {
+		if err := db.Table.Put(record.Key, record.Val, record.Seq); err != nil {
 			return err
 		}
 	case ActionDelete:
-		if err := db.Table.Delete(record.Key); err != nil {
+		if err := db.Table.Delete(record.Key, record.Seq); err != nil {
 			return err
 		}
 	}
@@ -236,13 +527,13 @@ func (db *DB) compact() error {
 		return err
 	}
 
-	if len(db.Table.Immutable) > 0 {
+	if db.Table.Immutable != nil && db.Table.Immutable.Len() > 0 {
 		if err := db.writeSSTable(); err != nil {
 			return err
 		}
 	}
 	db.Table.Immutable = db.Table.MemTable
-	db.Table.MemTable = make(map[string][]byte)
+	db.Table.MemTable = newSkipList(db.Table.cmp)
 	return nil
 }
 
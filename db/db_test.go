@@ -3,12 +3,11 @@ package db
 import (
 	"fmt"
 	"log"
-	"os"
 	"testing"
 )
 
 func TestDB_Get_Put_Delete(t *testing.T) {
-	db, err := Open(nil)
+	db, err := Open(OptionStorage(NewMemStorage()))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -41,9 +40,46 @@ func TestDB_Get_Put_Delete(t *testing.T) {
 	}
 }
 
+// TestDB_RestoresSeqAcrossReopen guards against a write after reopening
+// reusing a sequence number already on disk, which would make latestVersion
+// resolve back to the stale pre-close version instead of the new one.
+func TestDB_RestoresSeqAcrossReopen(t *testing.T) {
+	storage := NewMemStorage()
+
+	d, err := Open(OptionStorage(storage))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := d.Put([]byte("x"), []byte("1")); err != nil {
+		log.Fatal(err)
+	}
+	if err := d.Put([]byte("x"), []byte("2")); err != nil {
+		log.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	reopened, err := Open(OptionStorage(storage))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if err := reopened.Put([]byte("x"), []byte("3")); err != nil {
+		log.Fatal(err)
+	}
+	val, err := reopened.Get([]byte("x"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if string(val) != "3" {
+		t.Errorf("expected: %s, got: %s", "3", string(val))
+	}
+}
+
 func BenchmarkDB_Get(b *testing.B) {
-	dir, _ := os.MkdirTemp("/tmp/pluto", "db")
-	db, err := Open(OptionDir(dir))
+	db, err := Open(OptionStorage(NewMemStorage()))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -59,8 +95,7 @@ func BenchmarkDB_Get(b *testing.B) {
 }
 
 func BenchmarkDB_Put(b *testing.B) {
-	dir, _ := os.MkdirTemp("/tmp/pluto", "db")
-	db, err := Open(OptionDir(dir))
+	db, err := Open(OptionStorage(NewMemStorage()))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -72,8 +107,7 @@ func BenchmarkDB_Put(b *testing.B) {
 }
 
 func BenchmarkDB_Delete(b *testing.B) {
-	dir, _ := os.MkdirTemp("/tmp/pluto", "db")
-	db, err := Open(OptionDir(dir))
+	db, err := Open(OptionStorage(NewMemStorage()))
 	if err != nil {
 		log.Fatal(err)
 	}
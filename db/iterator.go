@@ -0,0 +1,258 @@
+package db
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Iterator walks a range of keys in sorted order. Implementations hide
+// shadowed and deleted entries, so every key it yields is live as of
+// whatever sequence number it was built for.
+type Iterator interface {
+	First() bool
+	Last() bool
+	Seek(key []byte) bool
+	Next() bool
+	Prev() bool
+	Key() []byte
+	Value() []byte
+	Release()
+	Error() error
+}
+
+// newIterator builds the merged, deduplicated view of [start, limit) as
+// of seq, the same way writeSSTable builds an SST: materialize it fully,
+// sorted under the Table's Comparator, up front, then hand back a cursor
+// over it. Sources are merged newest-first (MemTable, then Immutable,
+// then L0 newest-file-first, then L1, L2, ...) so that when two sources
+// hold the same key, the merge keeps the one from the higher-priority
+// source and drops the rest.
+func (t *Table) newIterator(start, limit []byte, seq uint64) Iterator {
+	t.mu.Lock()
+	cmp := t.cmp
+	sources := [][]KV{resolveSkipList(t.MemTable, seq)}
+	if t.Immutable != nil {
+		sources = append(sources, resolveSkipList(t.Immutable, seq))
+	}
+	manifest := t.manifest
+	t.mu.Unlock()
+
+	if manifest != nil {
+		l0 := append([]FileMeta(nil), manifest.Levels[0]...)
+		sort.Slice(l0, func(i, j int) bool { return l0[i].Num > l0[j].Num })
+		for _, f := range l0 {
+			kvs, err := t.readSSTable(f.Num)
+			if err != nil {
+				return &sliceIterator{err: err}
+			}
+			sources = append(sources, resolveVersionedKVs(kvs, seq, cmp))
+		}
+		for level := 1; level < len(manifest.Levels); level++ {
+			var levelKVs []KV
+			for _, f := range manifest.Levels[level] {
+				kvs, err := t.readSSTable(f.Num)
+				if err != nil {
+					return &sliceIterator{err: err}
+				}
+				levelKVs = append(levelKVs, kvs...)
+			}
+			sources = append(sources, resolveVersionedKVs(levelKVs, seq, cmp))
+		}
+	}
+
+	merged := mergeSources(sources, start, limit, cmp)
+	return &sliceIterator{data: merged, pos: -1, cmp: cmp}
+}
+
+// resolveSkipList collapses a MemTable/Immutable skip list down to at
+// most one KV per key: the newest version visible at seq. The skip list
+// already walks in comparator order, so the result comes out sorted.
+func resolveSkipList(s *skipList, seq uint64) []KV {
+	kvs := make([]KV, 0, s.Len())
+	for n := s.front(); n != nil; n = n.forward[0] {
+		if e, ok := latestVersion(n.versions, seq); ok {
+			kvs = append(kvs, KV{Key: n.key, Val: e.Val, Deleted: e.Deleted, Seq: e.Seq})
+		}
+	}
+	return kvs
+}
+
+// resolveVersionedKVs collapses an SST file's rows (sorted by key, then
+// seq ascending within a key) down to at most one KV per key: the newest
+// version visible at seq. Ties on Seq break in that row order, i.e. the
+// last one written wins, matching latestVersion and prunedVersions.
+func resolveVersionedKVs(kvs []KV, seq uint64, cmp Comparator) []KV {
+	out := make([]KV, 0, len(kvs))
+	i := 0
+	for i < len(kvs) {
+		j := i
+		var best *KV
+		for j < len(kvs) && cmp.Compare(kvs[j].Key, kvs[i].Key) == 0 {
+			if kvs[j].Seq <= seq {
+				best = &kvs[j]
+			}
+			j++
+		}
+		if best != nil {
+			out = append(out, *best)
+		}
+		i = j
+	}
+	return out
+}
+
+// heapItem is one source's current head during the k-way merge.
+// priority is the source's rank (lower wins ties, i.e. shadows).
+type heapItem struct {
+	kv       KV
+	source   int
+	priority int
+	index    int
+}
+
+type mergeHeap struct {
+	items []*heapItem
+	cmp   Comparator
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+func (h *mergeHeap) Less(i, j int) bool {
+	if c := h.cmp.Compare(h.items[i].kv.Key, h.items[j].kv.Key); c != 0 {
+		return c < 0
+	}
+	return h.items[i].priority < h.items[j].priority
+}
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *mergeHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(*heapItem))
+}
+func (h *mergeHeap) Pop() interface{} {
+	old := h.items
+	n := len(old)
+	item := old[n-1]
+	h.items = old[:n-1]
+	return item
+}
+func (h *mergeHeap) peek() *heapItem { return h.items[0] }
+
+// mergeSources k-way merges sources (each already collapsed to one KV per
+// key and sorted under cmp) into a single sorted, deduplicated,
+// range-bounded slice, dropping tombstones and any key shadowed by a
+// higher-priority source.
+func mergeSources(sources [][]KV, start, limit []byte, cmp Comparator) []KV {
+	h := &mergeHeap{cmp: cmp}
+	heap.Init(h)
+	for si, src := range sources {
+		if idx := seekKVs(src, start, cmp); idx < len(src) {
+			heap.Push(h, &heapItem{kv: src[idx], source: si, priority: si, index: idx})
+		}
+	}
+
+	var out []KV
+	for h.Len() > 0 {
+		key := h.peek().kv.Key
+		var winner KV
+		have := false
+		for h.Len() > 0 && cmp.Compare(h.peek().kv.Key, key) == 0 {
+			top := heap.Pop(h).(*heapItem)
+			if !have {
+				winner = top.kv
+				have = true
+			}
+			src := sources[top.source]
+			if top.index+1 < len(src) {
+				heap.Push(h, &heapItem{kv: src[top.index+1], source: top.source, priority: top.priority, index: top.index + 1})
+			}
+		}
+		if limit != nil && cmp.Compare(key, limit) >= 0 {
+			break
+		}
+		if !winner.Deleted {
+			out = append(out, winner)
+		}
+	}
+	return out
+}
+
+func seekKVs(kvs []KV, start []byte, cmp Comparator) int {
+	if start == nil {
+		return 0
+	}
+	return sort.Search(len(kvs), func(i int) bool { return cmp.Compare(kvs[i].Key, start) >= 0 })
+}
+
+// sliceIterator is a cursor over a pre-materialized, already-filtered KV
+// slice.
+type sliceIterator struct {
+	data []KV
+	pos  int
+	err  error
+	cmp  Comparator
+}
+
+func (it *sliceIterator) First() bool {
+	if it.err != nil || len(it.data) == 0 {
+		return false
+	}
+	it.pos = 0
+	return true
+}
+
+func (it *sliceIterator) Last() bool {
+	if it.err != nil || len(it.data) == 0 {
+		return false
+	}
+	it.pos = len(it.data) - 1
+	return true
+}
+
+func (it *sliceIterator) Seek(key []byte) bool {
+	if it.err != nil {
+		return false
+	}
+	i := sort.Search(len(it.data), func(i int) bool { return it.cmp.Compare(it.data[i].Key, key) >= 0 })
+	if i >= len(it.data) {
+		return false
+	}
+	it.pos = i
+	return true
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.err != nil || it.pos+1 >= len(it.data) {
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *sliceIterator) Prev() bool {
+	if it.err != nil || it.pos <= 0 {
+		return false
+	}
+	it.pos--
+	return true
+}
+
+func (it *sliceIterator) Key() []byte {
+	if it.pos < 0 || it.pos >= len(it.data) {
+		return nil
+	}
+	return it.data[it.pos].Key
+}
+
+func (it *sliceIterator) Value() []byte {
+	if it.pos < 0 || it.pos >= len(it.data) {
+		return nil
+	}
+	return it.data[it.pos].Val
+}
+
+func (it *sliceIterator) Release() {
+	it.data = nil
+	it.pos = -1
+}
+
+func (it *sliceIterator) Error() error {
+	return it.err
+}
@@ -0,0 +1,94 @@
+package db
+
+import "math/rand"
+
+// The MemTable is a skip list instead of a plain map so that flushing and
+// iterating can walk it in key order directly, under whatever Comparator
+// the Table was opened with, instead of collecting every key and sorting.
+const (
+	skipListMaxLevel = 16
+	skipListP        = 0.25
+)
+
+type skipListNode struct {
+	key      []byte
+	versions []entry
+	forward  []*skipListNode
+}
+
+type skipList struct {
+	cmp   Comparator
+	head  *skipListNode
+	level int
+	len   int
+	rnd   *rand.Rand
+}
+
+func newSkipList(cmp Comparator) *skipList {
+	return &skipList{
+		cmp:   cmp,
+		head:  &skipListNode{forward: make([]*skipListNode, skipListMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(1)),
+	}
+}
+
+func (s *skipList) randomLevel() int {
+	lvl := 1
+	for lvl < skipListMaxLevel && s.rnd.Float64() < skipListP {
+		lvl++
+	}
+	return lvl
+}
+
+// find walks every level from the top down, returning the first node
+// whose key is >= key and, in update, the last node at each level that
+// falls before it (what Insert needs to splice a new node in).
+func (s *skipList) find(key []byte) (next *skipListNode, update [skipListMaxLevel]*skipListNode) {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && s.cmp.Compare(x.forward[i].key, key) < 0 {
+			x = x.forward[i]
+		}
+		update[i] = x
+	}
+	return x.forward[0], update
+}
+
+func (s *skipList) get(key []byte) (*skipListNode, bool) {
+	next, _ := s.find(key)
+	if next != nil && s.cmp.Compare(next.key, key) == 0 {
+		return next, true
+	}
+	return nil, false
+}
+
+// getOrInsert returns the node for key, creating an empty one if this is
+// the first version of this key ever seen in this table.
+func (s *skipList) getOrInsert(key []byte) *skipListNode {
+	next, update := s.find(key)
+	if next != nil && s.cmp.Compare(next.key, key) == 0 {
+		return next
+	}
+
+	lvl := s.randomLevel()
+	if lvl > s.level {
+		for i := s.level; i < lvl; i++ {
+			update[i] = s.head
+		}
+		s.level = lvl
+	}
+
+	node := &skipListNode{key: append([]byte(nil), key...), forward: make([]*skipListNode, lvl)}
+	for i := 0; i < lvl; i++ {
+		node.forward[i] = update[i].forward[i]
+		update[i].forward[i] = node
+	}
+	s.len++
+	return node
+}
+
+func (s *skipList) Len() int { return s.len }
+
+// front returns the first node in key order, or nil if the list is empty.
+func (s *skipList) front() *skipListNode { return s.head.forward[0] }
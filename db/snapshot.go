@@ -0,0 +1,43 @@
+package db
+
+import "sync/atomic"
+
+// Snapshot is a point-in-time view of the database: every Get and
+// iterator it hands out is pinned to the sequence number in effect when
+// the snapshot was taken, regardless of writes that land afterward.
+type Snapshot struct {
+	db       *DB
+	seq      uint64
+	released bool
+}
+
+// GetSnapshot captures the current sequence number and registers it so
+// compaction won't drop a version this snapshot might still need to read.
+func (db *DB) GetSnapshot() *Snapshot {
+	seq := atomic.LoadUint64(&db.seq)
+	db.addSnapshot(seq)
+	return &Snapshot{db: db, seq: seq}
+}
+
+// Get returns the value key had as of the snapshot's sequence number.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	return s.db.Table.GetAt(key, s.seq)
+}
+
+// NewIterator returns an Iterator over [start, limit) as of the
+// snapshot's sequence number. A nil start or limit leaves that end of
+// the range unbounded.
+func (s *Snapshot) NewIterator(start, limit []byte) Iterator {
+	return s.db.Table.newIterator(start, limit, s.seq)
+}
+
+// Release decrements the snapshot's refcount so compaction knows its
+// sequence number no longer needs to be preserved. It is safe to call
+// more than once.
+func (s *Snapshot) Release() {
+	if s.released {
+		return
+	}
+	s.released = true
+	s.db.removeSnapshot(s.seq)
+}
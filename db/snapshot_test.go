@@ -0,0 +1,87 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestSnapshot_IsolatedFromLaterWrites(t *testing.T) {
+	d, err := Open(OptionStorage(NewMemStorage()))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+
+	if err := d.Put([]byte("k1"), []byte("v1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	snap := d.GetSnapshot()
+	defer snap.Release()
+
+	if err := d.Put([]byte("k1"), []byte("v2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := d.Delete([]byte("k1")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	val, err := snap.Get([]byte("k1"))
+	if err != nil {
+		t.Fatalf("snapshot Get failed: %v", err)
+	}
+	if string(val) != "v1" {
+		t.Fatalf("expected snapshot to see v1, got %s", val)
+	}
+
+	if _, err := d.Get([]byte("k1")); err != ErrorKeyNotFound {
+		t.Fatalf("expected live read to see the delete, got err: %v", err)
+	}
+}
+
+func TestIterator_MergesSourcesInOrderAndHidesShadowed(t *testing.T) {
+	d, err := Open(OptionStorage(NewMemStorage()))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+
+	if err := d.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := d.Put([]byte("c"), []byte("3")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := d.Put([]byte("b"), []byte("old")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// Overwrite b in the MemTable, which must shadow the earlier version.
+	if err := d.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := d.Delete([]byte("c")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	snap := d.GetSnapshot()
+	defer snap.Release()
+
+	it := snap.NewIterator(nil, nil)
+	defer it.Release()
+
+	var keys, vals []string
+	for ok := it.First(); ok; ok = it.Next() {
+		keys = append(keys, string(it.Key()))
+		vals = append(vals, string(it.Value()))
+	}
+
+	expectedKeys := []string{"a", "b"}
+	expectedVals := []string{"1", "2"}
+	if len(keys) != len(expectedKeys) {
+		t.Fatalf("expected keys %v, got %v", expectedKeys, keys)
+	}
+	for i := range expectedKeys {
+		if keys[i] != expectedKeys[i] || vals[i] != expectedVals[i] {
+			t.Fatalf("expected keys %v vals %v, got keys %v vals %v", expectedKeys, expectedVals, keys, vals)
+		}
+	}
+}
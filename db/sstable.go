@@ -0,0 +1,425 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// An SST file is laid out as: a sequence of ~sstBlockSize data blocks
+// holding length-prefixed entries in key order, an index block (one entry
+// per data block: an upper bound on its keys, offset, and length), a bloom filter
+// covering every key in the file, and a fixed-size footer pointing at the
+// index and filter. Get loads and caches the index and filter once per
+// file, probes the filter to skip files that can't hold the key, then
+// binary-searches the index and reads exactly one block.
+const (
+	sstBlockSize = 4 * 1024
+	sstMagicStr  = "PLUTOSST"
+	sstFooterLen = 8 + 4 + 8 + 4 + len(sstMagicStr)
+)
+
+var sstMagic = []byte(sstMagicStr)
+
+var errShortSSTable = errors.New("sstable: truncated file")
+
+// indexEntry points at one data block: an upper bound on the keys it holds
+// (its largest key, or a shorter separator/successor when the Comparator
+// implements SeparatorComparator), so lookups can binary-search for the
+// right block, and its byte range.
+type indexEntry struct {
+	Largest []byte
+	Offset  int64
+	Length  int
+}
+
+func sstFileDesc(num int) FileDesc {
+	return FileDesc{Type: FileTypeSST, Num: num}
+}
+
+func encodeEntry(kv KV) []byte {
+	buf := make([]byte, 0, 4+len(kv.Key)+4+len(kv.Val)+1+8)
+	var lenBuf [4]byte
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(kv.Key)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, kv.Key...)
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(kv.Val)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, kv.Val...)
+	if kv.Deleted {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	var seqBuf [8]byte
+	binary.LittleEndian.PutUint64(seqBuf[:], kv.Seq)
+	buf = append(buf, seqBuf[:]...)
+	return buf
+}
+
+// decodeEntries decodes every entry packed back-to-back in buf. Entries
+// never encode their own boundaries beyond length prefixes, so this also
+// doubles as the data-section decoder for a full-file read.
+func decodeEntries(buf []byte) ([]KV, error) {
+	var kvs []KV
+	i := 0
+	for i < len(buf) {
+		if i+4 > len(buf) {
+			return nil, errShortSSTable
+		}
+		klen := int(binary.LittleEndian.Uint32(buf[i : i+4]))
+		i += 4
+		if i+klen+4 > len(buf) {
+			return nil, errShortSSTable
+		}
+		key := append([]byte(nil), buf[i:i+klen]...)
+		i += klen
+		vlen := int(binary.LittleEndian.Uint32(buf[i : i+4]))
+		i += 4
+		if i+vlen+1+8 > len(buf) {
+			return nil, errShortSSTable
+		}
+		val := append([]byte(nil), buf[i:i+vlen]...)
+		i += vlen
+		deleted := buf[i] == 1
+		i++
+		seq := binary.LittleEndian.Uint64(buf[i : i+8])
+		i += 8
+		kvs = append(kvs, KV{Key: key, Val: val, Deleted: deleted, Seq: seq})
+	}
+	return kvs, nil
+}
+
+func encodeIndex(index []indexEntry) []byte {
+	var buf []byte
+	var tmp [4]byte
+	for _, e := range index {
+		binary.LittleEndian.PutUint32(tmp[:], uint32(len(e.Largest)))
+		buf = append(buf, tmp[:]...)
+		buf = append(buf, e.Largest...)
+		var off [8]byte
+		binary.LittleEndian.PutUint64(off[:], uint64(e.Offset))
+		buf = append(buf, off[:]...)
+		binary.LittleEndian.PutUint32(tmp[:], uint32(e.Length))
+		buf = append(buf, tmp[:]...)
+	}
+	return buf
+}
+
+func decodeIndex(buf []byte) ([]indexEntry, error) {
+	var index []indexEntry
+	i := 0
+	for i < len(buf) {
+		if i+4 > len(buf) {
+			return nil, errShortSSTable
+		}
+		klen := int(binary.LittleEndian.Uint32(buf[i : i+4]))
+		i += 4
+		if i+klen+8+4 > len(buf) {
+			return nil, errShortSSTable
+		}
+		largest := append([]byte(nil), buf[i:i+klen]...)
+		i += klen
+		offset := int64(binary.LittleEndian.Uint64(buf[i : i+8]))
+		i += 8
+		length := int(binary.LittleEndian.Uint32(buf[i : i+4]))
+		i += 4
+		index = append(index, indexEntry{Largest: largest, Offset: offset, Length: length})
+	}
+	return index, nil
+}
+
+// writeLevelSSTable writes data (already sorted by key, then by sequence
+// number within a key) to a new SST file and returns the FileMeta
+// describing it. It does not place the file in any level; callers do
+// that in the manifest.
+func (t *Table) writeLevelSSTable(data []KV) (FileMeta, error) {
+	num := t.manifest.NextFile
+	t.manifest.NextFile++
+
+	f, err := t.storage.Create(sstFileDesc(num))
+	if err != nil {
+		return FileMeta{}, err
+	}
+	defer f.Close()
+
+	var offset int64
+	var index []indexEntry
+	var block []byte
+	var blockLargest []byte
+
+	// flush closes out the current block. nextKey, if any, is the first
+	// key of the block after it; when the comparator supports it, the
+	// index stores the shortest separator between the two instead of the
+	// full largest key, which is the whole point of SeparatorComparator.
+	flush := func(nextKey []byte) error {
+		if len(block) == 0 {
+			return nil
+		}
+		if _, err := f.Write(block); err != nil {
+			return err
+		}
+		idxKey := blockLargest
+		if sep, ok := t.cmp.(SeparatorComparator); ok {
+			if nextKey != nil {
+				idxKey = sep.Separator(blockLargest, nextKey)
+			} else {
+				idxKey = sep.Successor(blockLargest)
+			}
+		}
+		index = append(index, indexEntry{Largest: idxKey, Offset: offset, Length: len(block)})
+		offset += int64(len(block))
+		block = nil
+		return nil
+	}
+
+	// A run is every version of one key; runs are never split across
+	// blocks, so every version of a key sits in the one block the index
+	// directs a lookup for it to.
+	i := 0
+	for i < len(data) {
+		j := i
+		var run []byte
+		for j < len(data) && t.cmp.Compare(data[j].Key, data[i].Key) == 0 {
+			run = append(run, encodeEntry(data[j])...)
+			j++
+		}
+		if len(block) > 0 && len(block)+len(run) > sstBlockSize {
+			if err := flush(data[i].Key); err != nil {
+				return FileMeta{}, err
+			}
+		}
+		block = append(block, run...)
+		blockLargest = data[i].Key
+		i = j
+	}
+	if err := flush(nil); err != nil {
+		return FileMeta{}, err
+	}
+
+	indexOffset := offset
+	indexBuf := encodeIndex(index)
+	if _, err := f.Write(indexBuf); err != nil {
+		return FileMeta{}, err
+	}
+	offset += int64(len(indexBuf))
+
+	keys := make([][]byte, len(data))
+	for i, kv := range data {
+		keys[i] = kv.Key
+	}
+	filterOffset := offset
+	filterBuf := buildBloomFilter(keys, t.bloomBitsPerKey).encode()
+	if _, err := f.Write(filterBuf); err != nil {
+		return FileMeta{}, err
+	}
+	offset += int64(len(filterBuf))
+
+	footer := make([]byte, sstFooterLen)
+	binary.LittleEndian.PutUint64(footer[0:8], uint64(indexOffset))
+	binary.LittleEndian.PutUint32(footer[8:12], uint32(len(indexBuf)))
+	binary.LittleEndian.PutUint64(footer[12:20], uint64(filterOffset))
+	binary.LittleEndian.PutUint32(footer[20:24], uint32(len(filterBuf)))
+	copy(footer[24:], sstMagic)
+	if _, err := f.Write(footer); err != nil {
+		return FileMeta{}, err
+	}
+	offset += int64(len(footer))
+
+	meta := FileMeta{Num: num, Size: offset}
+	if len(data) > 0 {
+		meta.Smallest = data[0].Key
+		meta.Largest = data[len(data)-1].Key
+	}
+	return meta, nil
+}
+
+// readFooter reads and validates the fixed-size footer at the end of an
+// SST file.
+func readFooter(raw []byte) (indexOffset int64, indexLen uint32, filterOffset int64, filterLen uint32, err error) {
+	if len(raw) < sstFooterLen {
+		return 0, 0, 0, 0, errShortSSTable
+	}
+	footer := raw[len(raw)-sstFooterLen:]
+	if !bytes.Equal(footer[24:], sstMagic) {
+		return 0, 0, 0, 0, errShortSSTable
+	}
+	indexOffset = int64(binary.LittleEndian.Uint64(footer[0:8]))
+	indexLen = binary.LittleEndian.Uint32(footer[8:12])
+	filterOffset = int64(binary.LittleEndian.Uint64(footer[12:20]))
+	filterLen = binary.LittleEndian.Uint32(footer[20:24])
+	return indexOffset, indexLen, filterOffset, filterLen, nil
+}
+
+// readSSTable decodes every row in the file, in order. Compaction and
+// tests use this full scan; Get uses the index and filter instead so it
+// never has to load a whole file just to answer one key.
+func (t *Table) readSSTable(num int) ([]KV, error) {
+	r, err := t.storage.Open(sstFileDesc(num))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	raw, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	indexOffset, _, _, _, err := readFooter(raw)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntries(raw[:indexOffset])
+}
+
+func (t *Table) removeSSTable(num int) {
+	_ = t.storage.Remove(sstFileDesc(num))
+	t.evictCache(num)
+}
+
+// loadIndexAndFilter returns num's block index and bloom filter, caching
+// both after the first load since they're read on every lookup.
+func (t *Table) loadIndexAndFilter(num int) ([]indexEntry, *bloomFilter, error) {
+	t.cacheMu.Lock()
+	if idx, ok := t.indexCache[num]; ok {
+		filter := t.filterCache[num]
+		t.cacheMu.Unlock()
+		return idx, filter, nil
+	}
+	t.cacheMu.Unlock()
+
+	r, err := t.storage.Open(sstFileDesc(num))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	raw, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	indexOffset, indexLen, filterOffset, filterLen, err := readFooter(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	index, err := decodeIndex(raw[indexOffset : indexOffset+int64(indexLen)])
+	if err != nil {
+		return nil, nil, err
+	}
+	filter, err := decodeBloomFilter(raw[filterOffset : filterOffset+int64(filterLen)])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t.cacheMu.Lock()
+	t.ensureCaches()
+	t.indexCache[num] = index
+	t.filterCache[num] = filter
+	t.cacheMu.Unlock()
+	return index, filter, nil
+}
+
+type blockCacheKey struct {
+	num    int
+	offset int64
+}
+
+// loadBlock reads one data block, by (file, offset), caching it so a hot
+// block isn't re-read from disk on every lookup that hits it.
+func (t *Table) loadBlock(num int, offset int64, length int) ([]byte, error) {
+	key := blockCacheKey{num: num, offset: offset}
+	t.cacheMu.Lock()
+	if block, ok := t.blockCache[key]; ok {
+		t.cacheMu.Unlock()
+		return block, nil
+	}
+	t.cacheMu.Unlock()
+
+	r, err := t.storage.Open(sstFileDesc(num))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	block := make([]byte, length)
+	if _, err := r.ReadAt(block, offset); err != nil {
+		return nil, err
+	}
+
+	t.cacheMu.Lock()
+	t.ensureCaches()
+	t.blockCache[key] = block
+	t.cacheMu.Unlock()
+	return block, nil
+}
+
+func (t *Table) ensureCaches() {
+	if t.indexCache == nil {
+		t.indexCache = make(map[int][]indexEntry)
+	}
+	if t.filterCache == nil {
+		t.filterCache = make(map[int]*bloomFilter)
+	}
+	if t.blockCache == nil {
+		t.blockCache = make(map[blockCacheKey][]byte)
+	}
+}
+
+func (t *Table) evictCache(num int) {
+	t.cacheMu.Lock()
+	defer t.cacheMu.Unlock()
+	delete(t.indexCache, num)
+	delete(t.filterCache, num)
+	for key := range t.blockCache {
+		if key.num == num {
+			delete(t.blockCache, key)
+		}
+	}
+}
+
+// lookupSSTable probes f's bloom filter to skip files that can't hold
+// key, then binary-searches the block index and reads exactly one block,
+// scanning that key's versions (sorted with the newest last) for the
+// newest one no later than maxSeq.
+func (t *Table) lookupSSTable(f FileMeta, key []byte, maxSeq uint64) (KV, bool, error) {
+	index, filter, err := t.loadIndexAndFilter(f.Num)
+	if err != nil {
+		return KV{}, false, err
+	}
+	if !filter.mayContain(key) {
+		return KV{}, false, nil
+	}
+
+	cmp := t.cmp
+	i := sort.Search(len(index), func(i int) bool { return cmp.Compare(index[i].Largest, key) >= 0 })
+	if i >= len(index) {
+		return KV{}, false, nil
+	}
+
+	block, err := t.loadBlock(f.Num, index[i].Offset, index[i].Length)
+	if err != nil {
+		return KV{}, false, err
+	}
+	kvs, err := decodeEntries(block)
+	if err != nil {
+		return KV{}, false, err
+	}
+
+	var best KV
+	found := false
+	for _, kv := range kvs {
+		if cmp.Compare(kv.Key, key) == 0 && kv.Seq <= maxSeq {
+			best = kv
+			found = true
+		}
+	}
+	return best, found, nil
+}
+
+// findFileForKey binary-searches a non-overlapping, Smallest-sorted level
+// for the one file whose key range could hold key.
+func findFileForKey(files []FileMeta, key []byte, cmp Comparator) (FileMeta, bool) {
+	i := sort.Search(len(files), func(i int) bool { return cmp.Compare(files[i].Largest, key) >= 0 })
+	if i < len(files) && cmp.Compare(files[i].Smallest, key) <= 0 {
+		return files[i], true
+	}
+	return FileMeta{}, false
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSSTable_WriteReadRoundTrip(t *testing.T) {
+	table := newTestTable(t)
+
+	data := []KV{
+		{Key: []byte("a"), Val: []byte("1"), Seq: 1},
+		{Key: []byte("b"), Val: []byte("2"), Seq: 2},
+		{Key: []byte("c"), Val: []byte("3"), Seq: 3},
+	}
+	meta, err := table.writeLevelSSTable(data)
+	if err != nil {
+		t.Fatalf("writeLevelSSTable failed: %v", err)
+	}
+
+	got, err := table.readSSTable(meta.Num)
+	if err != nil {
+		t.Fatalf("readSSTable failed: %v", err)
+	}
+	if len(got) != len(data) {
+		t.Fatalf("expected %d rows, got %d", len(data), len(got))
+	}
+	for i, kv := range data {
+		if string(got[i].Key) != string(kv.Key) || string(got[i].Val) != string(kv.Val) {
+			t.Fatalf("row %d: expected %+v, got %+v", i, kv, got[i])
+		}
+	}
+
+	kv, ok, err := table.lookupSSTable(meta, []byte("b"), maxSeq)
+	if err != nil {
+		t.Fatalf("lookupSSTable failed: %v", err)
+	}
+	if !ok || string(kv.Val) != "2" {
+		t.Fatalf("expected to find b=2, got %+v ok=%v", kv, ok)
+	}
+
+	if _, ok, err := table.lookupSSTable(meta, []byte("missing"), maxSeq); err != nil {
+		t.Fatalf("lookupSSTable failed: %v", err)
+	} else if ok {
+		t.Fatalf("expected missing key not to be found")
+	}
+}
+
+func TestSSTable_SpansMultipleBlocks(t *testing.T) {
+	table := newTestTable(t)
+
+	// Each value is large enough that a handful of rows already cross the
+	// 4KB block boundary, exercising the block index's binary search.
+	big := make([]byte, 512)
+	data := make([]KV, 0, 32)
+	for i := 0; i < 32; i++ {
+		data = append(data, KV{Key: []byte(fmt.Sprintf("k%03d", i)), Val: big, Seq: uint64(i + 1)})
+	}
+	meta, err := table.writeLevelSSTable(data)
+	if err != nil {
+		t.Fatalf("writeLevelSSTable failed: %v", err)
+	}
+
+	index, _, err := table.loadIndexAndFilter(meta.Num)
+	if err != nil {
+		t.Fatalf("loadIndexAndFilter failed: %v", err)
+	}
+	if len(index) < 2 {
+		t.Fatalf("expected data to span multiple blocks, got %d", len(index))
+	}
+
+	for _, kv := range data {
+		got, ok, err := table.lookupSSTable(meta, kv.Key, maxSeq)
+		if err != nil {
+			t.Fatalf("lookupSSTable(%s) failed: %v", kv.Key, err)
+		}
+		if !ok || string(got.Val) != string(kv.Val) {
+			t.Fatalf("expected to find %s, got %+v ok=%v", kv.Key, got, ok)
+		}
+	}
+}
+
+func TestBloomFilter_NoFalseNegatives(t *testing.T) {
+	keys := make([][]byte, 0, 200)
+	for i := 0; i < 200; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("key-%d", i)))
+	}
+	filter := buildBloomFilter(keys, defaultBloomBitsPerKey)
+	for _, k := range keys {
+		if !filter.mayContain(k) {
+			t.Fatalf("expected filter to report %s as present", k)
+		}
+	}
+}
+
+func TestTable_RemoveSSTableEvictsCache(t *testing.T) {
+	table := newTestTable(t)
+	meta, err := table.writeLevelSSTable([]KV{{Key: []byte("a"), Val: []byte("1"), Seq: 1}})
+	if err != nil {
+		t.Fatalf("writeLevelSSTable failed: %v", err)
+	}
+	if _, _, err := table.loadIndexAndFilter(meta.Num); err != nil {
+		t.Fatalf("loadIndexAndFilter failed: %v", err)
+	}
+
+	table.removeSSTable(meta.Num)
+
+	if _, ok := table.indexCache[meta.Num]; ok {
+		t.Fatalf("expected index cache entry to be evicted")
+	}
+	if _, err := table.storage.Open(sstFileDesc(meta.Num)); !os.IsNotExist(err) {
+		t.Fatalf("expected SST file to be removed, open err: %v", err)
+	}
+}
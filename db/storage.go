@@ -0,0 +1,345 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileType identifies which kind of file a FileDesc refers to. Storage
+// implementations use it together with FileDesc.Num to derive a name:
+// the WAL is a singleton (Num is unused), Manifest uses Num only to tell
+// the live manifest from its tmp copy, SST files are numbered, and
+// Current would name the file that points at the live manifest, for a
+// Storage that needs one.
+type FileType int
+
+const (
+	FileTypeWAL FileType = iota
+	FileTypeSST
+	FileTypeManifest
+	FileTypeCurrent
+)
+
+// manifestFileNum and manifestTmpFileNum distinguish the live manifest
+// from the scratch copy save() writes before renaming it into place.
+const (
+	manifestFileNum    = 0
+	manifestTmpFileNum = 1
+)
+
+// FileDesc names one file a Storage manages.
+type FileDesc struct {
+	Type FileType
+	Num  int
+}
+
+func (fd FileDesc) fileName() string {
+	switch fd.Type {
+	case FileTypeWAL:
+		return "wal.txt"
+	case FileTypeManifest:
+		if fd.Num == manifestTmpFileNum {
+			return "MANIFEST.tmp"
+		}
+		return "MANIFEST"
+	case FileTypeCurrent:
+		return "CURRENT"
+	case FileTypeSST:
+		return fmt.Sprintf("%d.sst", fd.Num)
+	default:
+		return ""
+	}
+}
+
+// parseFileName is fileName's inverse, used by List to recognize which
+// FileDesc a directory entry (or, for MemStorage, a map key) names.
+func parseFileName(name string) (FileDesc, bool) {
+	switch name {
+	case "wal.txt":
+		return FileDesc{Type: FileTypeWAL}, true
+	case "MANIFEST":
+		return FileDesc{Type: FileTypeManifest, Num: manifestFileNum}, true
+	case "MANIFEST.tmp":
+		return FileDesc{Type: FileTypeManifest, Num: manifestTmpFileNum}, true
+	case "CURRENT":
+		return FileDesc{Type: FileTypeCurrent}, true
+	}
+	if num, ok := strings.CutSuffix(name, ".sst"); ok {
+		if n, err := strconv.Atoi(num); err == nil {
+			return FileDesc{Type: FileTypeSST, Num: n}, true
+		}
+	}
+	return FileDesc{}, false
+}
+
+// Reader reads a file a Storage owns: ReadAt for a single block (the SST
+// block cache's access pattern) and ReadAll for the handful of callers
+// that want the whole thing (SST index/footer, WAL recovery).
+type Reader interface {
+	io.ReaderAt
+	io.Closer
+	ReadAll() ([]byte, error)
+}
+
+// Writer writes a file a Storage owns. Create always returns one
+// positioned to extend whatever the file already holds, which is what
+// lets the WAL keep appending to the same file across restarts; a
+// caller that needs a guaranteed-empty file (the manifest's tmp copy)
+// removes it first.
+type Writer interface {
+	io.Writer
+	io.Closer
+}
+
+// Releaser releases something a Storage handed out, such as the lock
+// Storage.Lock acquires.
+type Releaser interface {
+	Release()
+}
+
+// Storage abstracts the directory newWAL and Table.writeSSTable used to
+// read and write directly with os calls, so a database can run against
+// an in-memory Storage (tests, the cwd-writing Open(nil) case) as easily
+// as a real directory. See NewFileStorage and NewMemStorage.
+type Storage interface {
+	Create(fd FileDesc) (Writer, error)
+	Open(fd FileDesc) (Reader, error)
+	Remove(fd FileDesc) error
+	List(t FileType) ([]FileDesc, error)
+	Rename(src, dst FileDesc) error
+	Lock() (Releaser, error)
+}
+
+// ErrStorageLocked is returned by Lock when another Storage (or, for
+// NewFileStorage, another process) already holds the lock.
+var ErrStorageLocked = errors.New("db: storage already locked")
+
+// fileStorage is the Storage every DB uses unless OptionStorage says
+// otherwise: every FileDesc maps to a real file in Dir.
+type fileStorage struct {
+	dir string
+}
+
+// NewFileStorage returns a Storage backed by real files in dir, the
+// behavior every Table used before Storage existed.
+func NewFileStorage(dir string) Storage {
+	return &fileStorage{dir: dir}
+}
+
+func (s *fileStorage) path(fd FileDesc) string {
+	return filepath.Join(s.dir, fd.fileName())
+}
+
+func (s *fileStorage) Create(fd FileDesc) (Writer, error) {
+	return os.OpenFile(s.path(fd), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+}
+
+func (s *fileStorage) Open(fd FileDesc) (Reader, error) {
+	f, err := os.Open(s.path(fd))
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader{f: f}, nil
+}
+
+func (s *fileStorage) Remove(fd FileDesc) error {
+	return os.Remove(s.path(fd))
+}
+
+func (s *fileStorage) Rename(src, dst FileDesc) error {
+	return os.Rename(s.path(src), s.path(dst))
+}
+
+func (s *fileStorage) List(t FileType) ([]FileDesc, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []FileDesc
+	for _, e := range entries {
+		if fd, ok := parseFileName(e.Name()); ok && fd.Type == t {
+			out = append(out, fd)
+		}
+	}
+	return out, nil
+}
+
+// Lock takes out an exclusive lock on Dir by creating a LOCK file with
+// O_EXCL, so a second process opening the same directory fails instead
+// of corrupting it.
+func (s *fileStorage) Lock() (Releaser, error) {
+	path := filepath.Join(s.dir, "LOCK")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrStorageLocked
+		}
+		return nil, err
+	}
+	return &fileLock{f: f, path: path}, nil
+}
+
+type fileLock struct {
+	f    *os.File
+	path string
+}
+
+func (l *fileLock) Release() {
+	l.f.Close()
+	os.Remove(l.path)
+}
+
+type fileReader struct {
+	f *os.File
+}
+
+func (r *fileReader) ReadAt(p []byte, off int64) (int, error) { return r.f.ReadAt(p, off) }
+func (r *fileReader) Close() error                            { return r.f.Close() }
+
+func (r *fileReader) ReadAll() ([]byte, error) {
+	if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r.f)
+}
+
+// memStorage is an in-memory Storage: every FileDesc maps to a []byte
+// held in a map instead of a file on disk, which is what lets tests and
+// benchmarks run hermetically instead of writing into the process's cwd.
+// Open(nil) does not use it: with no OptionStorage, Open still uses
+// NewFileStorage(Dir), and Dir defaults to ".".
+type memStorage struct {
+	mu     sync.Mutex
+	files  map[string][]byte
+	locked bool
+}
+
+// NewMemStorage returns an in-memory Storage. Nothing it writes survives
+// past the process, which is the point: tests get a fresh, isolated
+// store without touching the filesystem.
+func NewMemStorage() Storage {
+	return &memStorage{files: make(map[string][]byte)}
+}
+
+func (s *memStorage) Create(fd FileDesc) (Writer, error) {
+	name := fd.fileName()
+	s.mu.Lock()
+	if _, ok := s.files[name]; !ok {
+		s.files[name] = []byte{}
+	}
+	s.mu.Unlock()
+	return &memWriter{s: s, name: name}, nil
+}
+
+func (s *memStorage) Open(fd FileDesc) (Reader, error) {
+	name := fd.fileName()
+	s.mu.Lock()
+	_, ok := s.files[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &memReader{s: s, name: name}, nil
+}
+
+func (s *memStorage) Remove(fd FileDesc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, fd.fileName())
+	return nil
+}
+
+func (s *memStorage) Rename(src, dst FileDesc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	srcName := src.fileName()
+	data, ok := s.files[srcName]
+	if !ok {
+		return os.ErrNotExist
+	}
+	s.files[dst.fileName()] = data
+	delete(s.files, srcName)
+	return nil
+}
+
+func (s *memStorage) List(t FileType) ([]FileDesc, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []FileDesc
+	for name := range s.files {
+		if fd, ok := parseFileName(name); ok && fd.Type == t {
+			out = append(out, fd)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStorage) Lock() (Releaser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked {
+		return nil, ErrStorageLocked
+	}
+	s.locked = true
+	return &memLock{s: s}, nil
+}
+
+type memLock struct {
+	s *memStorage
+}
+
+func (l *memLock) Release() {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+	l.s.locked = false
+}
+
+type memWriter struct {
+	s    *memStorage
+	name string
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	w.s.files[w.name] = append(w.s.files[w.name], p...)
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error { return nil }
+
+type memReader struct {
+	s    *memStorage
+	name string
+}
+
+func (r *memReader) ReadAt(p []byte, off int64) (int, error) {
+	r.s.mu.Lock()
+	data := r.s.files[r.name]
+	r.s.mu.Unlock()
+	if off < 0 || off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *memReader) Close() error { return nil }
+
+func (r *memReader) ReadAll() ([]byte, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	data := r.s.files[r.name]
+	out := make([]byte, len(data))
+	copy(out, data)
+	return out, nil
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"os"
+	"testing"
+)
+
+// testStorages returns one of each Storage implementation, keyed by name,
+// so a test can run against both, since they're expected to behave
+// identically from a caller's point of view.
+func testStorages(t *testing.T) map[string]Storage {
+	dir, err := os.MkdirTemp("", "storage_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return map[string]Storage{
+		"FileStorage": NewFileStorage(dir),
+		"MemStorage":  NewMemStorage(),
+	}
+}
+
+func TestStorage_CreateWriteOpenReadAt(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			fd := sstFileDesc(1)
+			w, err := s.Create(fd)
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			if _, err := w.Write([]byte("hello world")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			r, err := s.Open(fd)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer r.Close()
+
+			buf := make([]byte, 5)
+			if _, err := r.ReadAt(buf, 6); err != nil {
+				t.Fatalf("ReadAt failed: %v", err)
+			}
+			if string(buf) != "world" {
+				t.Fatalf("expected world, got %s", buf)
+			}
+
+			all, err := r.ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll failed: %v", err)
+			}
+			if string(all) != "hello world" {
+				t.Fatalf("expected hello world, got %s", all)
+			}
+		})
+	}
+}
+
+func TestStorage_CreateAppendsToExistingFile(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			fd := FileDesc{Type: FileTypeWAL}
+			w1, err := s.Create(fd)
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			w1.Write([]byte("first"))
+			w1.Close()
+
+			w2, err := s.Create(fd)
+			if err != nil {
+				t.Fatalf("second Create failed: %v", err)
+			}
+			w2.Write([]byte("second"))
+			w2.Close()
+
+			r, err := s.Open(fd)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer r.Close()
+			all, err := r.ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll failed: %v", err)
+			}
+			if string(all) != "firstsecond" {
+				t.Fatalf("expected firstsecond, got %s", all)
+			}
+		})
+	}
+}
+
+func TestStorage_RemoveRenameList(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			fd1, fd2 := sstFileDesc(1), sstFileDesc(2)
+			for _, fd := range []FileDesc{fd1, fd2} {
+				w, err := s.Create(fd)
+				if err != nil {
+					t.Fatalf("Create failed: %v", err)
+				}
+				w.Close()
+			}
+
+			files, err := s.List(FileTypeSST)
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(files) != 2 {
+				t.Fatalf("expected 2 SST files, got %d", len(files))
+			}
+
+			if err := s.Remove(fd1); err != nil {
+				t.Fatalf("Remove failed: %v", err)
+			}
+			if _, err := s.Open(fd1); !os.IsNotExist(err) {
+				t.Fatalf("expected removed file to be gone, got err: %v", err)
+			}
+
+			fd3 := sstFileDesc(3)
+			if err := s.Rename(fd2, fd3); err != nil {
+				t.Fatalf("Rename failed: %v", err)
+			}
+			if _, err := s.Open(fd2); !os.IsNotExist(err) {
+				t.Fatalf("expected source of rename to be gone, got err: %v", err)
+			}
+			if _, err := s.Open(fd3); err != nil {
+				t.Fatalf("expected rename target to exist: %v", err)
+			}
+		})
+	}
+}
+
+func TestStorage_LockExcludesSecondLocker(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			lock, err := s.Lock()
+			if err != nil {
+				t.Fatalf("Lock failed: %v", err)
+			}
+			if _, err := s.Lock(); err == nil {
+				t.Fatalf("expected a second Lock to fail while the first is held")
+			}
+			lock.Release()
+			lock2, err := s.Lock()
+			if err != nil {
+				t.Fatalf("Lock after Release failed: %v", err)
+			}
+			lock2.Release()
+		})
+	}
+}
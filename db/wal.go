@@ -1,19 +1,51 @@
 package db
 
 import (
-	"fmt"
-	"io"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"log"
 	"os"
 	"sync"
 )
 
+// The WAL is framed the way goleveldb's log file is: the file is divided
+// into fixed-size blocks, and each block holds a sequence of physical
+// records (4-byte CRC32C + 2-byte length + 1-byte type, followed by the
+// payload). A logical write (a single Put/Delete or a whole batch) is one
+// payload that may be split into FIRST/MIDDLE/LAST fragments when it
+// doesn't fit in what's left of the current block.
+const (
+	blockSize  = 32 * 1024
+	headerSize = 4 + 2 + 1
+)
+
+type recordType byte
+
+const (
+	recordTypeFull recordType = 1 + iota
+	recordTypeFirst
+	recordTypeMiddle
+	recordTypeLast
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 type WAL struct {
 	Records []Record
-	Dir     string
 	mu      sync.Mutex
 
-	f *os.File
+	// Dropped counts bytes discarded during recovery because they failed
+	// their CRC or were cut off mid-record/mid-block.
+	Dropped int
+
+	// MaxSeq is the highest sequence number among records recovered from
+	// the log, or 0 if the log was empty; Open uses it to restore DB.seq.
+	MaxSeq uint64
+
+	storage  Storage
+	f        Writer
+	blockOff int
 }
 
 type Record struct {
@@ -21,54 +53,234 @@ type Record struct {
 	Key    []byte
 	Val    []byte
 	Action Action
+	Seq    uint64
+}
+
+func (w *WAL) Append(record Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Records = append(w.Records, record)
+	return w.writePayload(encodeGroup(record.Seq, []Record{record}))
 }
 
-func (r *Record) String() string {
-	return fmt.Sprintf("%d%s%s|%s", r.Len, r.Action, r.Key, r.Val)
+// AppendGroup writes every record in the batch as a single payload: a
+// group header (sequence number, record count) followed by the records
+// themselves. The payload is framed atomically at the block layer, so
+// recovery either decodes the whole group or drops it entirely.
+func (w *WAL) AppendGroup(seq uint64, records []Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Records = append(w.Records, records...)
+	return w.writePayload(encodeGroup(seq, records))
 }
 
-func parseRecord(data []byte) ([]Record, error) {
-	records := make([]Record, 0)
-	for i := 0; i < len(data); {
-		dataLen, ll := 0, 0
-		for ; data[i] >= '0' && data[i] <= '9'; i++ {
-			dataLen = dataLen*10 + int(data[i]-'0')
-			ll++
+// writePayload fragments payload across block boundaries and writes each
+// fragment as a physical record.
+func (w *WAL) writePayload(payload []byte) error {
+	first := true
+	for {
+		leftover := blockSize - w.blockOff
+		if leftover < headerSize {
+			if leftover > 0 {
+				if _, err := w.f.Write(make([]byte, leftover)); err != nil {
+					return err
+				}
+			}
+			w.blockOff = 0
+			leftover = blockSize
 		}
-		action := transAction(data[i])
-		i++
-		key := make([]byte, 0)
-		for data[i] != '|' {
-			key = append(key, data[i])
-			i++
+
+		avail := leftover - headerSize
+		fragment := payload
+		last := true
+		if len(payload) > avail {
+			fragment = payload[:avail]
+			last = false
+		}
+
+		var typ recordType
+		switch {
+		case first && last:
+			typ = recordTypeFull
+		case first && !last:
+			typ = recordTypeFirst
+		case !first && last:
+			typ = recordTypeLast
+		default:
+			typ = recordTypeMiddle
+		}
+		if err := w.writePhysicalRecord(typ, fragment); err != nil {
+			return err
+		}
+
+		payload = payload[len(fragment):]
+		first = false
+		if last {
+			return nil
+		}
+	}
+}
+
+func (w *WAL) writePhysicalRecord(typ recordType, data []byte) error {
+	var hdr [headerSize]byte
+	binary.LittleEndian.PutUint16(hdr[4:6], uint16(len(data)))
+	hdr[6] = byte(typ)
+	crc := crc32.Checksum(append([]byte{byte(typ)}, data...), crc32cTable)
+	binary.LittleEndian.PutUint32(hdr[0:4], crc)
+
+	n, err := w.f.Write(append(hdr[:], data...))
+	if err != nil {
+		log.Printf("write log error: %v, n: %d", err, n)
+		return err
+	}
+	w.blockOff += headerSize + len(data)
+	return nil
+}
+
+// readPayloads scans data block by block and reassembles the logical
+// payloads written by writePayload. A payload whose CRC fails, or that is
+// cut off before it completes (a crash mid-write), is dropped along with
+// whatever fragments of it were already collected; dropped reports how
+// many bytes were discarded so callers can tell recovery wasn't silent.
+func readPayloads(data []byte) (payloads [][]byte, dropped int) {
+	var pending []byte
+	inProgress := false
+
+	discard := func(n int) {
+		dropped += n
+		if inProgress {
+			dropped += len(pending)
+			pending = nil
+			inProgress = false
+		}
+	}
+
+	for off := 0; off < len(data); {
+		blockRemain := blockSize - off%blockSize
+		if blockRemain < headerSize {
+			off += blockRemain
+			continue
+		}
+		if off+headerSize > len(data) {
+			discard(len(data) - off)
+			break
+		}
+		hdr := data[off : off+headerSize]
+		length := int(binary.LittleEndian.Uint16(hdr[4:6]))
+		typ := recordType(hdr[6])
+		if off+headerSize+length > len(data) {
+			discard(len(data) - off)
+			break
+		}
+		payload := data[off+headerSize : off+headerSize+length]
+		wantCRC := binary.LittleEndian.Uint32(hdr[0:4])
+		gotCRC := crc32.Checksum(append([]byte{byte(typ)}, payload...), crc32cTable)
+		off += headerSize + length
+		if wantCRC != gotCRC {
+			discard(headerSize + length)
+			continue
+		}
+
+		switch typ {
+		case recordTypeFull:
+			if inProgress {
+				discard(0)
+			}
+			payloads = append(payloads, append([]byte(nil), payload...))
+		case recordTypeFirst:
+			if inProgress {
+				discard(0)
+			}
+			pending = append([]byte(nil), payload...)
+			inProgress = true
+		case recordTypeMiddle:
+			if !inProgress {
+				dropped += len(payload)
+				continue
+			}
+			pending = append(pending, payload...)
+		case recordTypeLast:
+			if !inProgress {
+				dropped += len(payload)
+				continue
+			}
+			pending = append(pending, payload...)
+			payloads = append(payloads, pending)
+			pending = nil
+			inProgress = false
+		default:
+			discard(headerSize + length)
 		}
+	}
+	if inProgress {
+		dropped += len(pending)
+	}
+	return payloads, dropped
+}
+
+// encodeGroup serializes the shared sequence number and every record in
+// the group into a single payload: seq(8) + count(4), then for each
+// record action(1) + keyLen(4) + key + valLen(4) + val.
+func encodeGroup(seq uint64, records []Record) []byte {
+	buf := make([]byte, 12, 64)
+	binary.LittleEndian.PutUint64(buf[0:8], seq)
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(records)))
+	for _, r := range records {
+		buf = append(buf, byte(r.Action))
+		var lenBuf [4]byte
+		binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(r.Key)))
+		buf = append(buf, lenBuf[:]...)
+		buf = append(buf, r.Key...)
+		binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(r.Val)))
+		buf = append(buf, lenBuf[:]...)
+		buf = append(buf, r.Val...)
+	}
+	return buf
+}
+
+var errShortGroup = errors.New("wal: truncated group payload")
+
+func decodeGroup(payload []byte) ([]Record, error) {
+	if len(payload) < 12 {
+		return nil, errShortGroup
+	}
+	seq := binary.LittleEndian.Uint64(payload[0:8])
+	count := binary.LittleEndian.Uint32(payload[8:12])
+
+	i := 12
+	records := make([]Record, 0, count)
+	for n := uint32(0); n < count; n++ {
+		if i+1+4 > len(payload) {
+			return nil, errShortGroup
+		}
+		action := Action(payload[i])
 		i++
-		val := make([]byte, 0)
-		for j := 0; j < dataLen-len(key)-2; j++ {
-			val = append(val, data[i])
-			i++
+		klen := int(binary.LittleEndian.Uint32(payload[i : i+4]))
+		i += 4
+		if i+klen+4 > len(payload) {
+			return nil, errShortGroup
 		}
+		key := append([]byte(nil), payload[i:i+klen]...)
+		i += klen
+		vlen := int(binary.LittleEndian.Uint32(payload[i : i+4]))
+		i += 4
+		if i+vlen > len(payload) {
+			return nil, errShortGroup
+		}
+		val := append([]byte(nil), payload[i:i+vlen]...)
+		i += vlen
+
 		records = append(records, Record{
-			Len:    dataLen,
+			Len:    klen + vlen + 2,
 			Key:    key,
 			Val:    val,
 			Action: action,
+			Seq:    seq,
 		})
 	}
 	return records, nil
 }
 
-func (r *Record) Bytes() []byte {
-	return []byte(r.String())
-}
-
-func (w *WAL) Append(record Record) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	w.Records = append(w.Records, record)
-	return w.flush()
-}
-
 func (w *WAL) close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -84,44 +296,81 @@ func (w *WAL) last() *Record {
 	return &w.Records[len(w.Records)-1]
 }
 
-func (w *WAL) flush() error {
-	n, err := w.f.Write(w.last().Bytes())
-	if err != nil {
-		log.Printf("write log error: %v, n: %d", err, n)
-		return err
-	}
-	return nil
-}
-
+// compact starts a fresh WAL segment: the old file is dropped (its
+// records are now durable in an SST, or about to be once the caller
+// flushes the immutable memtable) and a new, empty one takes its place.
 func (w *WAL) compact() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	w.Records = make([]Record, 0)
-	w.f.Seek(0, 0)
-	w.f.Truncate(0)
+	w.blockOff = 0
+	if w.f != nil {
+		w.f.Close()
+	}
+	_ = w.storage.Remove(FileDesc{Type: FileTypeWAL})
+	f, err := w.storage.Create(FileDesc{Type: FileTypeWAL})
+	if err != nil {
+		return err
+	}
+	w.f = f
 	return nil
 }
 
-func newWAL(dir string) *WAL {
-	f, err := os.OpenFile(dir+"/wal.txt", os.O_CREATE|os.O_RDWR, 0666)
-	if err != nil {
+// newWAL opens (or creates) the WAL file in storage and replays whatever is
+// on disk. Each recovered group is replayed through replay as a unit, which
+// is how the MemTable is rebuilt instead of applying records one at a time.
+// A group that was corrupted or cut short is dropped rather than partially
+// applied; replay may be nil, in which case the file is parsed but nothing
+// is rebuilt (useful for tests that only care about the raw records).
+func newWAL(storage Storage, replay BatchReplay) *WAL {
+	fd := FileDesc{Type: FileTypeWAL}
+	var data []byte
+	if r, err := storage.Open(fd); err == nil {
+		data, err = r.ReadAll()
+		r.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if !os.IsNotExist(err) {
 		log.Fatal(err)
 	}
-	data, err := io.ReadAll(f)
-	if err != nil {
-		log.Fatal(err)
+
+	payloads, dropped := readPayloads(data)
+
+	var highSeq uint64
+	records := make([]Record, 0, len(payloads))
+	for _, p := range payloads {
+		g, err := decodeGroup(p)
+		if err != nil {
+			dropped += len(p)
+			continue
+		}
+		records = append(records, g...)
+		for _, rec := range g {
+			if rec.Seq > highSeq {
+				highSeq = rec.Seq
+			}
+		}
+		if replay != nil && len(g) > 0 {
+			if err := batchFromRecords(g).Replay(replay, g[0].Seq); err != nil {
+				log.Fatal(err)
+			}
+		}
 	}
-	records, err := parseRecord(data)
+
+	// Create, not Open: it always returns a Writer positioned to extend
+	// whatever's already there, which is exactly what appending after
+	// recovery needs.
+	f, err := storage.Create(fd)
 	if err != nil {
 		log.Fatal(err)
 	}
-	// seek to end, so we can append
-	if _, err := f.Seek(0, 2); err != nil {
-		log.Fatal(err)
-	}
 	return &WAL{
-		Records: records,
-		Dir:     dir,
-		f:       f,
+		Records:  records,
+		Dropped:  dropped,
+		MaxSeq:   highSeq,
+		storage:  storage,
+		f:        f,
+		blockOff: len(data) % blockSize,
 	}
 }
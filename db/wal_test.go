@@ -7,6 +7,21 @@ import (
 	"testing"
 )
 
+type replayRecorder struct {
+	puts    []Record
+	deletes []Record
+}
+
+func (r *replayRecorder) Put(key, val []byte, seq uint64) error {
+	r.puts = append(r.puts, Record{Key: key, Val: val, Seq: seq})
+	return nil
+}
+
+func (r *replayRecorder) Delete(key []byte, seq uint64) error {
+	r.deletes = append(r.deletes, Record{Key: key, Seq: seq})
+	return nil
+}
+
 func TestWAL(t *testing.T) {
 	dir, err := os.MkdirTemp("", "wal_test")
 	if err != nil {
@@ -14,42 +29,50 @@ func TestWAL(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 
-	wal := newWAL(dir)
+	wal := newWAL(NewFileStorage(dir), nil)
 
-	// Test Append method
 	record1 := Record{
 		Len:    12,
 		Key:    []byte("hello"),
 		Val:    []byte("world"),
 		Action: ActionPut,
+		Seq:    1,
 	}
 	err = wal.Append(record1)
 	if err != nil {
 		t.Fatalf("Append failed: %v", err)
 	}
 	record2 := Record{
-		Len:    12,
+		Len:    7,
 		Key:    []byte("hello"),
-		Val:    []byte("world"),
+		Val:    nil,
 		Action: ActionDelete,
+		Seq:    2,
 	}
 	err = wal.Append(record2)
 	if err != nil {
 		t.Fatalf("Append failed: %v", err)
 	}
 
-	// Test parseRecord function
-	data, err := os.ReadFile(filepath.Join(dir, ".wal"))
+	data, err := os.ReadFile(filepath.Join(dir, "wal.txt"))
 	if err != nil {
 		t.Fatalf("failed to read WAL file: %v", err)
 	}
-	records, err := parseRecord(data)
-	if err != nil {
-		t.Fatalf("parseRecord failed: %v", err)
+	payloads, dropped := readPayloads(data)
+	if dropped != 0 {
+		t.Fatalf("expected no dropped bytes, got %d", dropped)
+	}
+	records := make([]Record, 0)
+	for _, p := range payloads {
+		g, err := decodeGroup(p)
+		if err != nil {
+			t.Fatalf("decodeGroup failed: %v", err)
+		}
+		records = append(records, g...)
 	}
 	expectedRecords := []Record{record1, record2}
 	if !reflect.DeepEqual(records, expectedRecords) {
-		t.Fatalf("parseRecord returned incorrect result, expected: %v, got: %v", expectedRecords, records)
+		t.Fatalf("decoded records incorrect, expected: %v, got: %v", expectedRecords, records)
 	}
 
 	// Test compact method
@@ -57,7 +80,7 @@ func TestWAL(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Compact failed: %v", err)
 	}
-	data, err = os.ReadFile(filepath.Join(dir, ".wal"))
+	data, err = os.ReadFile(filepath.Join(dir, "wal.txt"))
 	if err != nil {
 		t.Fatalf("failed to read WAL file after compact: %v", err)
 	}
@@ -65,3 +88,87 @@ func TestWAL(t *testing.T) {
 		t.Fatalf("WAL file should be empty after compact, got length: %d", len(data))
 	}
 }
+
+func TestWAL_RecoversValidRecordsBeforeBitFlip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wal_corrupt_bitflip")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	wal := newWAL(NewFileStorage(dir), nil)
+	good := Record{Key: []byte("k1"), Val: []byte("v1"), Action: ActionPut, Seq: 1}
+	if err := wal.Append(good); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	goodEnd := wal.blockOff
+
+	bad := Record{Key: []byte("k2"), Val: []byte("v2"), Action: ActionPut, Seq: 2}
+	if err := wal.Append(bad); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	wal.close()
+
+	path := filepath.Join(dir, "wal.txt")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read WAL file: %v", err)
+	}
+	// Flip every bit of the first byte of the second record's payload; the first record,
+	// which ends at goodEnd, must be untouched.
+	data[goodEnd+headerSize] ^= 0xFF
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatalf("failed to rewrite WAL file: %v", err)
+	}
+
+	recorder := &replayRecorder{}
+	recovered := newWAL(NewFileStorage(dir), recorder)
+	defer recovered.close()
+
+	if recovered.Dropped == 0 {
+		t.Fatalf("expected corrupted record to be counted as dropped")
+	}
+	if len(recorder.puts) != 1 || string(recorder.puts[0].Key) != "k1" {
+		t.Fatalf("expected only k1 to survive recovery, got %v", recorder.puts)
+	}
+}
+
+func TestWAL_RecoversValidRecordsBeforeTruncation(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wal_corrupt_truncate")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	wal := newWAL(NewFileStorage(dir), nil)
+	good := Record{Key: []byte("k1"), Val: []byte("v1"), Action: ActionPut, Seq: 1}
+	if err := wal.Append(good); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	truncated := Record{Key: []byte("k2"), Val: []byte("v2"), Action: ActionPut, Seq: 2}
+	if err := wal.Append(truncated); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	wal.close()
+
+	path := filepath.Join(dir, "wal.txt")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read WAL file: %v", err)
+	}
+	// Cut the file off in the middle of the second record.
+	if err := os.WriteFile(path, data[:len(data)-3], 0666); err != nil {
+		t.Fatalf("failed to truncate WAL file: %v", err)
+	}
+
+	recorder := &replayRecorder{}
+	recovered := newWAL(NewFileStorage(dir), recorder)
+	defer recovered.close()
+
+	if recovered.Dropped == 0 {
+		t.Fatalf("expected truncated tail to be counted as dropped")
+	}
+	if len(recorder.puts) != 1 || string(recorder.puts[0].Key) != "k1" {
+		t.Fatalf("expected only k1 to survive recovery, got %v", recorder.puts)
+	}
+}